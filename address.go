@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// addressResult holds the outcome of checking a service at one of
+// the addresses its host resolves to. It is used for output.
+type addressResult struct {
+	Address string   `json:"address"`
+	Status  int      `json:"status"`
+	State   string   `json:"state"`
+	Message string   `json:"message,omitempty"`
+	Latency duration `json:"latency"`
+}
+
+// checkAddresses resolves the host of the service's URL, and
+// requests the URL from each address concurrently. Requests are
+// otherwise unchanged, so carry the same Host header and TLS server
+// name. Redirects to the same host and port are followed at the
+// same address, and others as usual. The service is up if every address is up, down if too few
+// addresses are up to satisfy its address policy, and otherwise
+// degraded.
+func checkAddresses(s *service, r *result) {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		r.State = stateDown
+		r.Message = err.Error()
+		return
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	ips, err := net.DefaultResolver.LookupHost(ctx, u.Hostname())
+	cancel()
+	if err != nil {
+		r.State = stateDown
+		r.Status = http.StatusServiceUnavailable
+		r.Message = err.Error()
+		return
+	}
+
+	target := net.JoinHostPort(u.Hostname(), port)
+
+	r.Addresses = make([]*addressResult, len(ips))
+	var wg sync.WaitGroup
+	wg.Add(len(ips))
+	for i, ip := range ips {
+		r.Addresses[i] = &addressResult{Address: net.JoinHostPort(ip, port)}
+		go func(ar *addressResult) {
+			defer wg.Done()
+			dialer := &net.Dialer{}
+			transport := &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					if addr == target {
+						addr = ar.Address
+					}
+					return dialer.DialContext(ctx, network, addr)
+				},
+			}
+			defer transport.CloseIdleConnections()
+			start := time.Now()
+			status, _, err := get(s, transport)
+			ar.Latency = duration(time.Since(start))
+			ar.Status = status
+			ar.State = httpState(status)
+			if err != nil {
+				ar.State = stateDown
+				ar.Message = err.Error()
+			}
+		}(r.Addresses[i])
+	}
+	wg.Wait()
+
+	up := 0
+	var failed []string
+	for _, ar := range r.Addresses {
+		if ar.State == stateUp {
+			up++
+			continue
+		}
+		msg := ar.Message
+		if msg == "" {
+			msg = http.StatusText(ar.Status)
+		}
+		failed = append(failed, fmt.Sprintf("%s: %s", ar.Address, msg))
+		if r.Status == 0 {
+			r.Status = ar.Status
+		}
+	}
+	var ok bool
+	switch s.AddressPolicy {
+	case "any":
+		ok = up > 0
+	case "majority":
+		ok = up > len(ips)/2
+	default:
+		ok = up == len(ips)
+	}
+	switch {
+	case up == len(ips):
+		r.State = stateUp
+		r.Status = http.StatusOK
+	case ok:
+		r.State = stateDegraded
+	default:
+		r.State = stateDown
+	}
+	if len(failed) > 0 {
+		r.Message = fmt.Sprintf("%d of %d addresses up; %s",
+			up, len(ips), strings.Join(failed, "; "))
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckAddressesRedirect(t *testing.T) {
+	// The redirect target listens on another port, as an https
+	// server would.
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer target.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/moved", http.StatusMovedPermanently)
+		case "/moved":
+			http.Redirect(w, r, target.URL+"/health", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	for _, path := range []string{"/old", "/moved"} {
+		s := &service{Name: "web", URL: ts.URL + path, PerAddress: true}
+		r := &result{}
+		checkAddresses(s, r)
+		if r.State != stateUp {
+			t.Errorf("%s: state = %q (%s), want up", path, r.State, r.Message)
+		}
+		if len(r.Addresses) != 1 || r.Addresses[0].Address != ts.Listener.Addr().String() {
+			t.Errorf("%s: addresses = %v, want %s", path, r.Addresses, ts.Listener.Addr())
+		}
+	}
+}
+
+func TestCheckAddressesPolicy(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	for _, policy := range []string{"", "any", "majority"} {
+		s := &service{Name: "web", URL: ts.URL, PerAddress: true, AddressPolicy: policy}
+		r := &result{}
+		checkAddresses(s, r)
+		if r.State != stateDown || r.Status != http.StatusServiceUnavailable {
+			t.Errorf("policy %q: result = %q %d, want down 503", policy, r.State, r.Status)
+		}
+		if want := "0 of 1 addresses up; " + ts.Listener.Addr().String() + ": Service Unavailable"; r.Message != want {
+			t.Errorf("policy %q: message = %q, want %q", policy, r.Message, want)
+		}
+	}
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// amqpCheck configures a check of an AMQP 0-9-1 broker, such as
+// RabbitMQ. The check opens a connection, and optionally sends a
+// message to itself through a temporary queue.
+type amqpCheck struct {
+	// Address is the broker's host and port. The port defaults to
+	// 5671 with TLS, and 5672 otherwise.
+	Address string `json:"address"`
+	// TLS is one of "tls" or "none" (the default).
+	TLS string `json:"tls,omitempty"`
+	// InsecureSkipVerify disables verification of the server's
+	// certificate.
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+	// Username and Password default to "guest".
+	Username string `json:"username,omitempty"`
+	Password secret `json:"password,omitempty"`
+	// VHost is the virtual host to open, "/" by default.
+	VHost string `json:"vhost,omitempty"`
+
+	// RoundTrip declares a temporary queue, consumes from it and
+	// publishes a message to it, timing its delivery.
+	RoundTrip bool `json:"round_trip,omitempty"`
+
+	// Timeout limits the whole check (default 10s).
+	Timeout *duration `json:"timeout,omitempty"`
+	// MaxLatency is the connection or round trip time above which
+	// the service is degraded.
+	MaxLatency *duration `json:"max_latency,omitempty"`
+}
+
+func (c *amqpCheck) init() error {
+	if c.Address == "" {
+		return fmt.Errorf("amqp check requires an address")
+	}
+	switch c.TLS {
+	case "tls", "none":
+	case "":
+		c.TLS = "none"
+	default:
+		return fmt.Errorf("unknown tls mode %q", c.TLS)
+	}
+	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+		port := "5672"
+		if c.TLS == "tls" {
+			port = "5671"
+		}
+		c.Address = net.JoinHostPort(c.Address, port)
+	}
+	if c.Username == "" {
+		c.Username = "guest"
+		if c.Password == "" {
+			c.Password = "guest"
+		}
+	}
+	if c.VHost == "" {
+		c.VHost = "/"
+	}
+	if c.Timeout == nil {
+		d := duration(10 * time.Second)
+		c.Timeout = &d
+	}
+	return nil
+}
+
+// checkAMQP connects to the broker, reporting the time taken to
+// complete the connection handshake as the "connect" metric and the
+// time taken for a message to be delivered as the "round_trip"
+// metric.
+func checkAMQP(s *service, _ *serviceState, r *result) {
+	c := s.AMQP
+	r.URL = "amqp://" + c.Address
+	if c.TLS == "tls" {
+		r.URL = "amqps://" + c.Address
+	}
+
+	start := time.Now()
+	conn, err := c.dial()
+	if err != nil {
+		r.State = stateDown
+		r.Message = err.Error()
+		return
+	}
+	defer conn.close()
+	r.Metrics = append(r.Metrics, latencyMetric("connect", time.Since(start), c.MaxLatency))
+
+	if c.RoundTrip {
+		d, err := conn.roundTrip()
+		if err != nil {
+			r.State = stateDown
+			r.Message = fmt.Sprintf("round trip: %v", err)
+			return
+		}
+		r.Metrics = append(r.Metrics, latencyMetric("round_trip", d, c.MaxLatency))
+	}
+
+	r.State = stateUp
+	for _, m := range r.Metrics {
+		r.State = worse(r.State, m.State)
+	}
+	if r.State != stateUp {
+		r.Message = "slow response"
+	}
+}
+
+// AMQP frame types.
+const (
+	amqpFrameMethod    = 1
+	amqpFrameHeader    = 2
+	amqpFrameBody      = 3
+	amqpFrameHeartbeat = 8
+	amqpFrameEnd       = 0xce
+)
+
+// AMQP classes and methods, as class<<16 | method.
+const (
+	amqpConnectionStart   = 10<<16 | 10
+	amqpConnectionStartOk = 10<<16 | 11
+	amqpConnectionTune    = 10<<16 | 30
+	amqpConnectionTuneOk  = 10<<16 | 31
+	amqpConnectionOpen    = 10<<16 | 40
+	amqpConnectionOpenOk  = 10<<16 | 41
+	amqpConnectionClose   = 10<<16 | 50
+	amqpConnectionCloseOk = 10<<16 | 51
+	amqpChannelOpen       = 20<<16 | 10
+	amqpChannelOpenOk     = 20<<16 | 11
+	amqpChannelClose      = 20<<16 | 40
+	amqpQueueDeclare      = 50<<16 | 10
+	amqpQueueDeclareOk    = 50<<16 | 11
+	amqpBasicConsume      = 60<<16 | 20
+	amqpBasicConsumeOk    = 60<<16 | 21
+	amqpBasicPublish      = 60<<16 | 40
+	amqpBasicDeliver      = 60<<16 | 60
+)
+
+// amqpConn is a minimal AMQP 0-9-1 client connection, supporting only
+// what is needed to send a message to itself.
+type amqpConn struct {
+	conn     net.Conn
+	r        *bufio.Reader
+	frameMax uint32
+}
+
+// dial connects to the broker and opens the virtual host.
+func (c *amqpCheck) dial() (*amqpConn, error) {
+	dialer := &net.Dialer{Timeout: time.Duration(*c.Timeout)}
+	var conn net.Conn
+	var err error
+	if c.TLS == "tls" {
+		host, _, _ := net.SplitHostPort(c.Address)
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.Address, &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: c.InsecureSkipVerify,
+		})
+	} else {
+		conn, err = dialer.Dial("tcp", c.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(time.Duration(*c.Timeout)))
+	ac := &amqpConn{conn: conn, r: bufio.NewReader(conn)}
+	if err := ac.handshake(c); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ac, nil
+}
+
+// handshake negotiates the connection and opens the virtual host.
+func (ac *amqpConn) handshake(c *amqpCheck) error {
+	if _, err := ac.conn.Write([]byte("AMQP\x00\x00\x09\x01")); err != nil {
+		return err
+	}
+	args, err := ac.expect(0, amqpConnectionStart)
+	if err != nil {
+		return fmt.Errorf("connection start: %w", err)
+	}
+	// Skip the protocol version and server properties.
+	a := &amqpReader{b: args}
+	a.next(2)
+	a.table()
+	if mechanisms := a.longstr(); !strings.Contains(" "+mechanisms+" ", " PLAIN ") {
+		return fmt.Errorf("PLAIN authentication not supported (%s)", mechanisms)
+	}
+	if a.err != nil {
+		return a.err
+	}
+
+	password, err := c.Password.value()
+	if err != nil {
+		return err
+	}
+	w := &amqpWriter{}
+	// Ask to be told why authentication fails, rather than having
+	// the connection closed.
+	w.table(map[string]any{
+		"product":      "mon",
+		"capabilities": map[string]any{"authentication_failure_close": true},
+	})
+	w.shortstr("PLAIN")
+	w.longstr("\x00" + c.Username + "\x00" + password)
+	w.shortstr("en_US")
+	if err := ac.method(0, amqpConnectionStartOk, w.Bytes()); err != nil {
+		return err
+	}
+
+	args, err = ac.expect(0, amqpConnectionTune)
+	if errors.Is(err, io.EOF) {
+		return fmt.Errorf("connection closed by broker; check credentials")
+	}
+	if err != nil {
+		return err
+	}
+	a = &amqpReader{b: args}
+	channelMax, frameMax := a.short(), a.long()
+	if a.err != nil {
+		return a.err
+	}
+	if frameMax == 0 || frameMax > 1<<20 {
+		frameMax = 1 << 20
+	}
+	ac.frameMax = frameMax
+	w = &amqpWriter{}
+	w.short(channelMax)
+	w.long(frameMax)
+	w.short(0)
+	if err := ac.method(0, amqpConnectionTuneOk, w.Bytes()); err != nil {
+		return err
+	}
+
+	w = &amqpWriter{}
+	w.shortstr(c.VHost)
+	w.shortstr("")
+	w.octet(0)
+	if err := ac.method(0, amqpConnectionOpen, w.Bytes()); err != nil {
+		return err
+	}
+	_, err = ac.expect(0, amqpConnectionOpenOk)
+	return err
+}
+
+// roundTrip opens a channel, declares an exclusive, server named
+// queue and consumes from it, then publishes a message to the queue
+// through the default exchange. It returns the time taken for the
+// message to be delivered.
+func (ac *amqpConn) roundTrip() (time.Duration, error) {
+	w := &amqpWriter{}
+	w.shortstr("")
+	if err := ac.method(1, amqpChannelOpen, w.Bytes()); err != nil {
+		return 0, err
+	}
+	if _, err := ac.expect(1, amqpChannelOpenOk); err != nil {
+		return 0, err
+	}
+
+	w = &amqpWriter{}
+	w.short(0)
+	w.shortstr("")
+	w.octet(0x0c) // exclusive, auto-delete
+	w.table(nil)
+	if err := ac.method(1, amqpQueueDeclare, w.Bytes()); err != nil {
+		return 0, err
+	}
+	args, err := ac.expect(1, amqpQueueDeclareOk)
+	if err != nil {
+		return 0, err
+	}
+	queue := (&amqpReader{b: args}).shortstr()
+
+	w = &amqpWriter{}
+	w.short(0)
+	w.shortstr(queue)
+	w.shortstr("")
+	w.octet(0x02) // no-ack
+	w.table(nil)
+	if err := ac.method(1, amqpBasicConsume, w.Bytes()); err != nil {
+		return 0, err
+	}
+	if _, err := ac.expect(1, amqpBasicConsumeOk); err != nil {
+		return 0, err
+	}
+
+	b := make([]byte, 12)
+	rand.Read(b)
+	body := hex.EncodeToString(b)
+	start := time.Now()
+	w = &amqpWriter{}
+	w.short(0)
+	w.shortstr("")
+	w.shortstr(queue)
+	w.octet(0)
+	if err := ac.method(1, amqpBasicPublish, w.Bytes()); err != nil {
+		return 0, err
+	}
+	w = &amqpWriter{}
+	w.short(60)
+	w.short(0)
+	w.longlong(uint64(len(body)))
+	w.short(0)
+	if err := ac.writeFrame(amqpFrameHeader, 1, w.Bytes()); err != nil {
+		return 0, err
+	}
+	if err := ac.writeFrame(amqpFrameBody, 1, []byte(body)); err != nil {
+		return 0, err
+	}
+
+	if _, err := ac.expect(1, amqpBasicDeliver); err != nil {
+		return 0, err
+	}
+	got, err := ac.content(1)
+	if err != nil {
+		return 0, err
+	}
+	if got != body {
+		return 0, fmt.Errorf("received unexpected message %q", truncate(got, 64))
+	}
+	return time.Since(start), nil
+}
+
+// close closes the connection, waiting briefly for the broker to
+// confirm.
+func (ac *amqpConn) close() {
+	w := &amqpWriter{}
+	w.short(200)
+	w.shortstr("")
+	w.short(0)
+	w.short(0)
+	if ac.method(0, amqpConnectionClose, w.Bytes()) == nil {
+		ac.conn.SetDeadline(time.Now().Add(time.Second))
+		ac.expect(0, amqpConnectionCloseOk)
+	}
+	ac.conn.Close()
+}
+
+// writeFrame writes a frame of the given type on channel ch.
+func (ac *amqpConn) writeFrame(typ byte, ch uint16, payload []byte) error {
+	w := &amqpWriter{}
+	w.octet(typ)
+	w.short(ch)
+	w.long(uint32(len(payload)))
+	w.Write(payload)
+	w.octet(amqpFrameEnd)
+	_, err := ac.conn.Write(w.Bytes())
+	return err
+}
+
+// readFrame reads the next frame, other than heartbeats.
+func (ac *amqpConn) readFrame() (typ byte, ch uint16, payload []byte, err error) {
+	for {
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(ac.r, header); err != nil {
+			return 0, 0, nil, err
+		}
+		// Brokers reply to an unsupported protocol header with
+		// the header they support.
+		if string(header[:4]) == "AMQP" {
+			return 0, 0, nil, fmt.Errorf("protocol version not supported")
+		}
+		typ, ch = header[0], binary.BigEndian.Uint16(header[1:])
+		size := binary.BigEndian.Uint32(header[3:])
+		if ac.frameMax > 0 && size > ac.frameMax {
+			return 0, 0, nil, fmt.Errorf("frame of %d bytes too large", size)
+		}
+		payload = make([]byte, size+1)
+		if _, err := io.ReadFull(ac.r, payload); err != nil {
+			return 0, 0, nil, err
+		}
+		if payload[size] != amqpFrameEnd {
+			return 0, 0, nil, fmt.Errorf("invalid frame end")
+		}
+		if typ != amqpFrameHeartbeat {
+			return typ, ch, payload[:size], nil
+		}
+	}
+}
+
+// method sends a method frame on channel ch.
+func (ac *amqpConn) method(ch uint16, method uint32, args []byte) error {
+	w := &amqpWriter{}
+	w.long(method)
+	w.Write(args)
+	return ac.writeFrame(amqpFrameMethod, ch, w.Bytes())
+}
+
+// expect reads the next method on channel ch, which must be want,
+// and returns its arguments. If the broker closes the channel or
+// connection instead, the reason is returned as an error.
+func (ac *amqpConn) expect(ch uint16, want uint32) ([]byte, error) {
+	typ, got, payload, err := ac.readFrame()
+	if err != nil {
+		return nil, err
+	}
+	if typ != amqpFrameMethod || len(payload) < 4 {
+		return nil, fmt.Errorf("unexpected frame type %d", typ)
+	}
+	method := binary.BigEndian.Uint32(payload)
+	args := payload[4:]
+	if method == amqpConnectionClose || method == amqpChannelClose {
+		a := &amqpReader{b: args}
+		code, text := a.short(), a.shortstr()
+		return nil, fmt.Errorf("closed by broker: %d %s", code, text)
+	}
+	if got != ch || method != want {
+		return nil, fmt.Errorf("unexpected method %d.%d", method>>16, method&0xffff)
+	}
+	return args, nil
+}
+
+// content reads a content header and body frames on channel ch,
+// returning the body.
+func (ac *amqpConn) content(ch uint16) (string, error) {
+	typ, _, payload, err := ac.readFrame()
+	if err != nil {
+		return "", err
+	}
+	if typ != amqpFrameHeader || len(payload) < 12 {
+		return "", fmt.Errorf("expected content header")
+	}
+	size := binary.BigEndian.Uint64(payload[4:])
+	var body []byte
+	for uint64(len(body)) < size {
+		typ, _, payload, err := ac.readFrame()
+		if err != nil {
+			return "", err
+		}
+		if typ != amqpFrameBody {
+			return "", fmt.Errorf("expected content body")
+		}
+		body = append(body, payload...)
+	}
+	return string(body), nil
+}
+
+// amqpWriter encodes AMQP method arguments.
+type amqpWriter struct {
+	bytes.Buffer
+}
+
+func (w *amqpWriter) octet(v byte)      { w.WriteByte(v) }
+func (w *amqpWriter) short(v uint16)    { binary.Write(w, binary.BigEndian, v) }
+func (w *amqpWriter) long(v uint32)     { binary.Write(w, binary.BigEndian, v) }
+func (w *amqpWriter) longlong(v uint64) { binary.Write(w, binary.BigEndian, v) }
+func (w *amqpWriter) shortstr(s string) { w.octet(byte(len(s))); w.WriteString(s) }
+func (w *amqpWriter) longstr(s string)  { w.long(uint32(len(s))); w.WriteString(s) }
+
+// table encodes a field table of string, boolean and table values.
+func (w *amqpWriter) table(t map[string]any) {
+	fields := &amqpWriter{}
+	for k, v := range t {
+		fields.shortstr(k)
+		switch v := v.(type) {
+		case string:
+			fields.octet('S')
+			fields.longstr(v)
+		case bool:
+			fields.octet('t')
+			if v {
+				fields.octet(1)
+			} else {
+				fields.octet(0)
+			}
+		case map[string]any:
+			fields.octet('F')
+			fields.table(v)
+		}
+	}
+	w.long(uint32(fields.Len()))
+	w.Write(fields.Bytes())
+}
+
+// amqpReader decodes AMQP method arguments. Reading past the end of
+// the arguments sets err.
+type amqpReader struct {
+	b   []byte
+	err error
+}
+
+func (a *amqpReader) next(n int) []byte {
+	if a.err != nil || n > len(a.b) {
+		a.err = fmt.Errorf("truncated method arguments")
+		return make([]byte, min(n, 8))
+	}
+	b := a.b[:n]
+	a.b = a.b[n:]
+	return b
+}
+
+func (a *amqpReader) short() uint16 { return binary.BigEndian.Uint16(a.next(2)) }
+func (a *amqpReader) long() uint32  { return binary.BigEndian.Uint32(a.next(4)) }
+
+func (a *amqpReader) shortstr() string {
+	return string(a.next(int(a.next(1)[0])))
+}
+
+func (a *amqpReader) longstr() string {
+	return string(a.next(int(a.long())))
+}
+
+// table skips a field table.
+func (a *amqpReader) table() {
+	a.next(int(a.long()))
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeBroker is a fake AMQP 0-9-1 broker, supporting the methods used
+// by amqpConn.
+type fakeBroker struct {
+	username, password string
+	// drop discards published messages.
+	drop bool
+}
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	ac := &amqpConn{conn: conn, r: bufio.NewReader(conn)}
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(ac.r, header); err != nil {
+		return
+	}
+
+	w := &amqpWriter{}
+	w.octet(0)
+	w.octet(9)
+	w.table(map[string]any{"product": "fake"})
+	w.longstr("AMQPLAIN PLAIN")
+	w.longstr("en_US")
+	ac.method(0, amqpConnectionStart, w.Bytes())
+
+	const queue = "amq.gen-fake"
+	for {
+		typ, ch, payload, err := ac.readFrame()
+		if err != nil || typ != amqpFrameMethod {
+			return
+		}
+		a := &amqpReader{b: payload[4:]}
+		switch binary.BigEndian.Uint32(payload) {
+		case amqpConnectionStartOk:
+			a.table()
+			a.shortstr()
+			if a.longstr() != "\x00"+b.username+"\x00"+b.password {
+				w := &amqpWriter{}
+				w.short(403)
+				w.shortstr("ACCESS_REFUSED - Login was refused")
+				w.short(10)
+				w.short(11)
+				ac.method(0, amqpConnectionClose, w.Bytes())
+				return
+			}
+			w := &amqpWriter{}
+			w.short(2047)
+			w.long(131072)
+			w.short(60)
+			ac.method(0, amqpConnectionTune, w.Bytes())
+		case amqpConnectionTuneOk:
+		case amqpConnectionOpen:
+			w := &amqpWriter{}
+			w.shortstr("")
+			ac.method(0, amqpConnectionOpenOk, w.Bytes())
+		case amqpConnectionClose:
+			ac.method(0, amqpConnectionCloseOk, nil)
+			return
+		case amqpChannelOpen:
+			w := &amqpWriter{}
+			w.longstr("")
+			ac.method(ch, amqpChannelOpenOk, w.Bytes())
+		case amqpQueueDeclare:
+			w := &amqpWriter{}
+			w.shortstr(queue)
+			w.long(0)
+			w.long(0)
+			ac.method(ch, amqpQueueDeclareOk, w.Bytes())
+		case amqpBasicConsume:
+			w := &amqpWriter{}
+			w.shortstr("ctag")
+			ac.method(ch, amqpBasicConsumeOk, w.Bytes())
+		case amqpBasicPublish:
+			body, err := ac.content(ch)
+			if err != nil || b.drop {
+				continue
+			}
+			// Deliver the message after a heartbeat, splitting
+			// its body across frames.
+			ac.writeFrame(amqpFrameHeartbeat, 0, nil)
+			w := &amqpWriter{}
+			w.shortstr("ctag")
+			w.longlong(1)
+			w.octet(0)
+			w.shortstr("")
+			w.shortstr(queue)
+			ac.method(ch, amqpBasicDeliver, w.Bytes())
+			w = &amqpWriter{}
+			w.short(60)
+			w.short(0)
+			w.longlong(uint64(len(body)))
+			w.short(0)
+			ac.writeFrame(amqpFrameHeader, ch, w.Bytes())
+			ac.writeFrame(amqpFrameBody, ch, []byte(body[:5]))
+			ac.writeFrame(amqpFrameBody, ch, []byte(body[5:]))
+		default:
+			return
+		}
+	}
+}
+
+// amqpService returns an amqp service checking a broker served by
+// handle.
+func amqpService(t *testing.T, handle func(net.Conn)) *service {
+	t.Helper()
+	timeout := duration(time.Second)
+	c := &amqpCheck{
+		Address:   serve(t, handle),
+		Username:  "mon",
+		Password:  "secret",
+		RoundTrip: true,
+		Timeout:   &timeout,
+	}
+	if err := c.init(); err != nil {
+		t.Fatal(err)
+	}
+	return &service{Name: "broker", Type: "amqp", AMQP: c}
+}
+
+func TestAMQP(t *testing.T) {
+	b := &fakeBroker{username: "mon", password: "secret"}
+	r := &result{}
+	checkAMQP(amqpService(t, b.serve), nil, r)
+	if r.State != stateUp {
+		t.Fatalf("state = %q (%s), want up", r.State, r.Message)
+	}
+	if len(r.Metrics) != 2 || r.Metrics[0].Name != "connect" || r.Metrics[1].Name != "round_trip" {
+		t.Errorf("metrics = %v, want connect and round_trip", r.Metrics)
+	}
+}
+
+func TestAMQPErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		handle func(net.Conn)
+		want   string
+	}{
+		{
+			"bad credentials",
+			(&fakeBroker{username: "mon", password: "other"}).serve,
+			"closed by broker: 403 ACCESS_REFUSED - Login was refused",
+		},
+		{
+			"not delivered",
+			(&fakeBroker{username: "mon", password: "secret", drop: true}).serve,
+			"round trip: read tcp",
+		},
+		{
+			"unsupported protocol",
+			func(conn net.Conn) {
+				conn.Read(make([]byte, 8))
+				conn.Write([]byte("AMQP\x00\x00\x09\x00"))
+			},
+			"connection start: protocol version not supported",
+		},
+		{
+			"invalid frame end",
+			func(conn net.Conn) {
+				conn.Read(make([]byte, 8))
+				conn.Write([]byte("\x01\x00\x00\x00\x00\x00\x04\x00\x0a\x00\x0a\x00"))
+			},
+			"connection start: invalid frame end",
+		},
+	}
+	for _, tt := range tests {
+		r := &result{}
+		checkAMQP(amqpService(t, tt.handle), nil, r)
+		if r.State != stateDown || !strings.HasPrefix(r.Message, tt.want) {
+			t.Errorf("%s: result = %q %q, want down %q", tt.name, r.State, r.Message, tt.want)
+		}
+	}
+}
+
+func TestAMQPWriter(t *testing.T) {
+	w := &amqpWriter{}
+	w.octet(1)
+	w.short(2)
+	w.long(3)
+	w.longlong(4)
+	w.shortstr("ab")
+	w.longstr("cd")
+	w.table(map[string]any{"t": true})
+	want := "01 0002 00000003 0000000000000004 02 6162 00000002 6364 00000004 0174 74 01"
+	if got, want := w.Bytes(), unhex(t, want); !bytes.Equal(got, want) {
+		t.Errorf("got % x, want % x", got, want)
+	}
+
+	// Nested tables and strings.
+	w = &amqpWriter{}
+	w.table(map[string]any{"c": map[string]any{"s": "x"}})
+	want = "0000000f 0163 46 00000008 0173 53 00000001 78"
+	if got, want := w.Bytes(), unhex(t, want); !bytes.Equal(got, want) {
+		t.Errorf("got % x, want % x", got, want)
+	}
+}
+
+func TestAMQPReader(t *testing.T) {
+	a := &amqpReader{b: unhex(t, "0002 00000003 02 6162 00000002 6364 00000001 ff")}
+	if got := a.short(); got != 2 {
+		t.Errorf("short = %d, want 2", got)
+	}
+	if got := a.long(); got != 3 {
+		t.Errorf("long = %d, want 3", got)
+	}
+	if got := a.shortstr(); got != "ab" {
+		t.Errorf("shortstr = %q, want ab", got)
+	}
+	if got := a.longstr(); got != "cd" {
+		t.Errorf("longstr = %q, want cd", got)
+	}
+	a.table()
+	if a.err != nil || len(a.b) != 0 {
+		t.Errorf("err = %v, %d bytes left, want none", a.err, len(a.b))
+	}
+
+	for _, bad := range []string{"", "05 6162", "00000003 6364", "000000ff"} {
+		a := &amqpReader{b: unhex(t, bad)}
+		a.longstr()
+		if a.err == nil {
+			t.Errorf("reading %s succeeded, want error", bad)
+		}
+	}
+}
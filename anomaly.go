@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// anomaly configures latency anomaly detection for a service. Each
+// up check's latency is compared to a baseline of the service's past
+// latencies, an exponentially weighted moving mean and variance kept
+// in mon's state. A service is degraded when its latency exceeds the
+// baseline by more than Sigma standard deviations for Consecutive
+// checks in a row.
+type anomaly struct {
+	Sigma       float64 `json:"sigma,omitempty"`
+	Consecutive int     `json:"consecutive,omitempty"`
+	// Alpha is the weight given to each new latency in the baseline.
+	Alpha float64 `json:"alpha,omitempty"`
+	// MinSamples is the number of checks the baseline must be built
+	// from before latencies are judged against it.
+	MinSamples int `json:"min_samples,omitempty"`
+	// MinDeviation is the smallest deviation from the baseline mean
+	// regarded as anomalous, so jitter in very consistent latencies
+	// is ignored.
+	MinDeviation *duration `json:"min_deviation,omitempty"`
+}
+
+func (an *anomaly) init() error {
+	if an.Sigma == 0 {
+		an.Sigma = 3
+	}
+	if an.Consecutive == 0 {
+		an.Consecutive = 3
+	}
+	if an.Alpha == 0 {
+		an.Alpha = 0.05
+	}
+	if an.MinSamples == 0 {
+		an.MinSamples = 20
+	}
+	if an.MinDeviation == nil {
+		d := duration(10 * time.Millisecond)
+		an.MinDeviation = &d
+	}
+	if an.Sigma < 0 || an.Consecutive < 0 || an.Alpha < 0 || an.Alpha > 1 || an.MinSamples < 0 {
+		return fmt.Errorf("invalid anomaly settings")
+	}
+	return nil
+}
+
+// anomalousWeight scales the weight given to latencies in the
+// baseline while a service is anomalous, so that a lasting change in
+// latency is reported for some time before the baseline learns it.
+const anomalousWeight = 0.01
+
+// baseline is a service's learned latency baseline. It is also used
+// for output.
+type baseline struct {
+	Mean      float64 `json:"mean_ms"`
+	Variance  float64 `json:"variance"`
+	StdDev    float64 `json:"stddev_ms"`
+	Samples   int     `json:"samples"`
+	Deviation float64 `json:"deviation,omitempty"`
+	Anomalous int     `json:"anomalous,omitempty"`
+}
+
+// update adds a latency sample of x milliseconds to the baseline.
+func (b *baseline) update(x, alpha float64) {
+	if b.Samples == 0 {
+		b.Mean = x
+	} else {
+		diff := x - b.Mean
+		incr := alpha * diff
+		b.Mean += incr
+		b.Variance = (1 - alpha) * (b.Variance + diff*incr)
+	}
+	b.StdDev = math.Sqrt(b.Variance)
+	b.Samples++
+}
+
+// detectAnomalies compares the latency of up services configured
+// for anomaly detection to their baselines, marking them degraded if
+// they have been anomalous for enough consecutive checks, and then
+// updates the baselines, giving anomalous latencies little weight.
+func detectAnomalies(results []*result, st *state) {
+	for _, r := range results {
+		an := r.service.Anomaly
+		if an == nil || r.State != stateUp {
+			continue
+		}
+		ss := st.service(r.Name)
+		if ss.Baseline == nil {
+			ss.Baseline = &baseline{}
+		}
+		b := ss.Baseline
+		x := float64(time.Duration(r.Latency)) / float64(time.Millisecond)
+
+		b.Deviation = 0
+		if b.StdDev > 0 {
+			b.Deviation = (x - b.Mean) / b.StdDev
+		}
+		minDev := float64(time.Duration(*an.MinDeviation)) / float64(time.Millisecond)
+		if b.Samples >= an.MinSamples && b.Deviation > an.Sigma && x-b.Mean > minDev {
+			b.Anomalous++
+		} else {
+			b.Anomalous = 0
+		}
+		if b.Anomalous >= an.Consecutive {
+			r.State = stateDegraded
+			r.Message = fmt.Sprintf("anomalous latency: %.0fms against baseline of %.0fms ± %.0fms",
+				x, b.Mean, b.StdDev)
+		}
+
+		// Output the baseline the check was judged against.
+		out := *b
+		r.Baseline = &out
+		alpha := an.Alpha
+		if b.Anomalous > 0 {
+			alpha *= anomalousWeight
+		}
+		b.update(x, alpha)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// checkLatencies runs anomaly detection on a service with each of the
+// given latencies in turn, in milliseconds, returning the resulting
+// states.
+func checkLatencies(t *testing.T, an *anomaly, st *state, latencies []float64) []string {
+	t.Helper()
+	if err := an.init(); err != nil {
+		t.Fatal(err)
+	}
+	s := &service{Name: "api", Anomaly: an}
+	var states []string
+	for _, ms := range latencies {
+		r := &result{
+			Name:    s.Name,
+			State:   stateUp,
+			Latency: duration(time.Duration(ms * float64(time.Millisecond))),
+			service: s,
+		}
+		detectAnomalies([]*result{r}, st)
+		states = append(states, r.State)
+	}
+	return states
+}
+
+// steady returns n latencies alternating 10ms either side of ms.
+func steady(ms float64, n int) []float64 {
+	latencies := make([]float64, n)
+	for i := range latencies {
+		latencies[i] = ms + float64(i%2*20-10)
+	}
+	return latencies
+}
+
+func TestAnomalyStepChange(t *testing.T) {
+	for _, consecutive := range []int{3, 4} {
+		st := &state{Services: make(map[string]*serviceState)}
+		states := checkLatencies(t, &anomaly{Consecutive: consecutive}, st, steady(100, 50))
+		for i, s := range states {
+			if s != stateUp {
+				t.Fatalf("consecutive %d: check %d at baseline = %s, want up", consecutive, i, s)
+			}
+		}
+
+		// A lasting doubling of latency is reported after
+		// consecutive checks, and for as long as it lasts.
+		states = checkLatencies(t, &anomaly{Consecutive: consecutive}, st, steady(200, 50))
+		for i, s := range states {
+			want := stateDegraded
+			if i < consecutive-1 {
+				want = stateUp
+			}
+			if s != want {
+				t.Errorf("consecutive %d: check %d after step = %s, want %s", consecutive, i, s, want)
+			}
+		}
+		if b := st.Services["api"].Baseline; b.Mean > 110 {
+			t.Errorf("consecutive %d: baseline mean = %.1fms, want about 100ms", consecutive, b.Mean)
+		}
+	}
+}
+
+func TestAnomalySpike(t *testing.T) {
+	st := &state{Services: make(map[string]*serviceState)}
+	latencies := append(steady(100, 30), 500, 500)
+	latencies = append(latencies, steady(100, 10)...)
+	for i, s := range checkLatencies(t, &anomaly{}, st, latencies) {
+		if s != stateUp {
+			t.Errorf("check %d = %s, want up", i, s)
+		}
+	}
+	if b := st.Services["api"].Baseline; b.Mean > 101 || b.Anomalous != 0 {
+		t.Errorf("baseline = %+v, want mean about 100ms and not anomalous", b)
+	}
+}
+
+func TestAnomalyMinSamples(t *testing.T) {
+	st := &state{Services: make(map[string]*serviceState)}
+	latencies := append(steady(100, 5), 200, 200, 200, 200)
+	for i, s := range checkLatencies(t, &anomaly{}, st, latencies) {
+		if s != stateUp {
+			t.Errorf("check %d = %s before the baseline is established, want up", i, s)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// assertion is a condition on a value in a JSON document. Without
+// any of Equals, Matches, Min or Max, the value must be present and
+// not null.
+type assertion struct {
+	// Path selects the value, such as "user.name" or "items[0].id".
+	// An empty path selects the whole document.
+	Path string `json:"path"`
+	// Equals is the JSON value the value must equal.
+	Equals json.RawMessage `json:"equals,omitempty"`
+	// Matches is a regular expression a string value, or the JSON
+	// encoding of any other value, must match.
+	Matches string `json:"matches,omitempty"`
+	// Min and Max bound a numeric value.
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+	// State is the state of the service if the assertion fails,
+	// "down" by default.
+	State string `json:"state,omitempty"`
+
+	equals any
+	re     *regexp.Regexp
+}
+
+func (a *assertion) init() error {
+	if a.Equals != nil {
+		if err := json.Unmarshal(a.Equals, &a.equals); err != nil {
+			return fmt.Errorf("assertion on %q: %w", a.Path, err)
+		}
+	}
+	if a.Matches != "" {
+		var err error
+		if a.re, err = regexp.Compile(a.Matches); err != nil {
+			return fmt.Errorf("assertion on %q: %w", a.Path, err)
+		}
+	}
+	switch a.State {
+	case "":
+		a.State = stateDown
+	case stateDegraded, stateDown:
+	default:
+		return fmt.Errorf("assertion on %q: invalid state %q", a.Path, a.State)
+	}
+	return nil
+}
+
+// check returns an error describing how v fails the assertion, or nil
+// if it holds.
+func (a *assertion) check(v any) error {
+	name := a.Path
+	if name == "" {
+		name = "document"
+	}
+	got, ok := jsonPath(v, a.Path)
+	if !ok {
+		return fmt.Errorf("%s not found", name)
+	}
+	if a.Equals != nil && !reflect.DeepEqual(got, a.equals) {
+		return fmt.Errorf("%s is %s, want %s", name, jsonString(got), jsonString(a.equals))
+	}
+	if a.re != nil {
+		s, ok := got.(string)
+		if !ok {
+			s = jsonString(got)
+		}
+		if !a.re.MatchString(s) {
+			return fmt.Errorf("%s is %s, want match for %q", name, jsonString(got), a.Matches)
+		}
+	}
+	if a.Min != nil || a.Max != nil {
+		n, ok := got.(float64)
+		if !ok {
+			return fmt.Errorf("%s is %s, not a number", name, jsonString(got))
+		}
+		if a.Min != nil && n < *a.Min {
+			return fmt.Errorf("%s is %v, want at least %v", name, n, *a.Min)
+		}
+		if a.Max != nil && n > *a.Max {
+			return fmt.Errorf("%s is %v, want at most %v", name, n, *a.Max)
+		}
+	}
+	if a.Equals == nil && a.re == nil && a.Min == nil && a.Max == nil && got == nil {
+		return fmt.Errorf("%s is null", name)
+	}
+	return nil
+}
+
+// checkAssertions checks v against each assertion, returning the
+// state of the service and a description of the assertions that
+// fail.
+func checkAssertions(assertions []*assertion, v any) (string, string) {
+	state := stateUp
+	var failures []string
+	for _, a := range assertions {
+		if err := a.check(v); err != nil {
+			state = worse(state, a.State)
+			failures = append(failures, err.Error())
+		}
+	}
+	return state, strings.Join(failures, "; ")
+}
+
+// jsonPath returns the value selected by path in v, a document
+// decoded from JSON. Path elements are separated by dots, and select
+// an object member by name or an array element by index. Indexes may
+// also be written in brackets, as in "items[0].id".
+func jsonPath(v any, path string) (any, bool) {
+	for _, elem := range pathElements(path) {
+		switch t := v.(type) {
+		case map[string]any:
+			var ok bool
+			if v, ok = t[elem]; !ok {
+				return nil, false
+			}
+		case []any:
+			i, err := strconv.Atoi(elem)
+			if err != nil || i < 0 || i >= len(t) {
+				return nil, false
+			}
+			v = t[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// pathElements splits a path as accepted by jsonPath into elements.
+func pathElements(path string) []string {
+	path = strings.ReplaceAll(path, "[", ".")
+	path = strings.ReplaceAll(path, "]", "")
+	path = strings.TrimPrefix(path, ".")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+// jsonString returns the JSON encoding of v, truncated for use in
+// messages.
+func jsonString(v any) string {
+	b, _ := json.Marshal(v)
+	return truncate(string(b), 64)
+}
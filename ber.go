@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BER tags used by LDAP.
+const (
+	berBoolean     = 0x01
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berEnumerated  = 0x0a
+	berSequence    = 0x30
+)
+
+// maxBERLength is the largest BER element read.
+const maxBERLength = 16 << 20
+
+// ber returns a BER element with the given tag, whose content is the
+// concatenation of content.
+func ber(tag byte, content ...[]byte) []byte {
+	n := 0
+	for _, c := range content {
+		n += len(c)
+	}
+	b := []byte{tag}
+	if n < 0x80 {
+		b = append(b, byte(n))
+	} else {
+		var l []byte
+		for v := n; v > 0; v >>= 8 {
+			l = append([]byte{byte(v)}, l...)
+		}
+		b = append(b, 0x80|byte(len(l)))
+		b = append(b, l...)
+	}
+	for _, c := range content {
+		b = append(b, c...)
+	}
+	return b
+}
+
+// berString returns a BER element with the given tag containing s.
+func berString(tag byte, s string) []byte {
+	return ber(tag, []byte(s))
+}
+
+// berInt returns a BER element with the given tag containing the
+// non-negative integer v.
+func berInt(tag byte, v int) []byte {
+	b := []byte{byte(v)}
+	for v >>= 8; v > 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return ber(tag, b)
+}
+
+// berBool returns a BER boolean element.
+func berBool(v bool) []byte {
+	if v {
+		return ber(berBoolean, []byte{0xff})
+	}
+	return ber(berBoolean, []byte{0})
+}
+
+// berNext splits the first BER element from b, returning its tag,
+// its content and the remainder of b.
+func berNext(b []byte) (tag byte, content, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errors.New("truncated BER element")
+	}
+	tag, n, b := b[0], int(b[1]), b[2:]
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 4 || len(b) < size {
+			return 0, nil, nil, errors.New("invalid BER length")
+		}
+		n = 0
+		for _, c := range b[:size] {
+			n = n<<8 | int(c)
+		}
+		b = b[size:]
+	}
+	if n > len(b) {
+		return 0, nil, nil, errors.New("truncated BER element")
+	}
+	return tag, b[:n], b[n:], nil
+}
+
+// berReadInt decodes the content of a BER integer or enumerated
+// element.
+func berReadInt(b []byte) int {
+	v := 0
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		v = -1
+	}
+	for _, c := range b {
+		v = v<<8 | int(c)
+	}
+	return v
+}
+
+// readBER reads a complete BER element from r.
+func readBER(r *bufio.Reader) ([]byte, error) {
+	header := make([]byte, 2, 6)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	n := int(header[1])
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 4 {
+			return nil, errors.New("invalid BER length")
+		}
+		l := make([]byte, size)
+		if _, err := io.ReadFull(r, l); err != nil {
+			return nil, err
+		}
+		header = append(header, l...)
+		n = 0
+		for _, c := range l {
+			n = n<<8 | int(c)
+		}
+	}
+	if n > maxBERLength {
+		return nil, fmt.Errorf("BER element of %d bytes too large", n)
+	}
+	b := make([]byte, len(header)+n)
+	copy(b, header)
+	if _, err := io.ReadFull(r, b[len(header):]); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Service states.
+const (
+	stateUp       = "up"
+	stateDegraded = "degraded"
+	stateDown     = "down"
+)
+
+// result holds the outcome of checking a single service. It is
+// used for output.
+type result struct {
+	Name     string    `json:"name"`
+	URL      string    `json:"url,omitempty"`
+	Status   int       `json:"status,omitempty"`
+	State    string    `json:"state"`
+	Message  string    `json:"message,omitempty"`
+	Latency  duration  `json:"latency"`
+	Baseline *baseline `json:"baseline,omitempty"`
+	// Stale is set if the service wasn't due to be checked, and the
+	// result is that of its last check.
+	Stale bool `json:"stale,omitempty"`
+	// EffectiveInterval is the minimum time until the service is
+	// next checked, if it has an interval.
+	EffectiveInterval *duration `json:"effective_interval,omitempty"`
+	// checked is when the service was checked.
+	checked time.Time
+
+	Metrics     []*metric        `json:"metrics,omitempty"`
+	Processes   []*processInfo   `json:"processes,omitempty"`
+	Unit        *unitStatus      `json:"unit,omitempty"`
+	Lines       []string         `json:"lines,omitempty"`
+	Domain      *domainInfo      `json:"domain,omitempty"`
+	Listings    []*listing       `json:"listings,omitempty"`
+	Object      *objectInfo      `json:"object,omitempty"`
+	Violations  []*violation     `json:"violations,omitempty"`
+	Differences []*difference    `json:"differences,omitempty"`
+	Addresses   []*addressResult `json:"addresses,omitempty"`
+	Diagnostics *diagnostics     `json:"diagnostics,omitempty"`
+	Remediation *remediation     `json:"remediation,omitempty"`
+
+	service *service
+}
+
+// summary returns a short description of the result.
+func (r *result) summary() string {
+	s := r.Message
+	if s == "" {
+		s = http.StatusText(r.Status)
+	}
+	if r.Remediation != nil {
+		s += "; " + r.Remediation.String()
+	}
+	return s
+}
+
+// checkers maps service types to the functions that check them. A
+// checker records the outcome of the check, including the service's
+// state, in the result. It may keep information between runs in the
+// service's persisted state.
+var checkers = map[string]func(s *service, ss *serviceState, r *result){
+	"http":       checkHTTP,
+	"host":       checkHost,
+	"process":    checkProcess,
+	"systemd":    checkSystemd,
+	"logfile":    checkLogfile,
+	"mailflow":   checkMailflow,
+	"domain":     checkDomain,
+	"dnsbl":      checkDNSBL,
+	"s3":         checkS3,
+	"ldap":       checkLDAP,
+	"amqp":       checkAMQP,
+	"nats":       checkNATS,
+	"graphql":    checkGraphQL,
+	"jsonrpc":    checkJSONRPC,
+	"compare":    checkCompare,
+	"statuspage": checkStatuspage,
+}
+
+// checkServices checks all services due to be checked concurrently,
+// returning results in the same order as the services provided. The
+// results of services not due are those of their last checks.
+func checkServices(services []*service, st *state, now time.Time) []*result {
+	results := make([]*result, len(services))
+	var wg sync.WaitGroup
+	for i, svc := range services {
+		ss := st.service(svc.Name)
+		if !svc.due(ss, now) {
+			r := *ss.Last
+			r.URL, r.Stale, r.service = svc.URL, true, svc
+			r.checked = ss.Checked
+			results[i] = &r
+			continue
+		}
+		results[i] = &result{
+			Name:    svc.Name,
+			URL:     svc.URL,
+			checked: now,
+			service: svc,
+		}
+		wg.Add(1)
+		go func(r *result, ss *serviceState) {
+			defer wg.Done()
+			start := time.Now()
+			checkers[r.service.Type](r.service, ss, r)
+			r.Latency = duration(time.Since(start))
+			ss.Checked = now
+		}(results[i], ss)
+	}
+	wg.Wait()
+	return results
+}
+
+// countFailures counts the consecutive down checks of each service
+// checked, which back off its interval and trigger its remediation.
+func countFailures(results []*result, st *state) {
+	for _, r := range results {
+		ss := st.service(r.Name)
+		if r.State != stateDown {
+			ss.Failures = 0
+			continue
+		}
+		ss.Failures++
+	}
+}
+
+// setIntervals records the effective interval of each service with
+// one in its result.
+func setIntervals(results []*result, st *state) {
+	for _, r := range results {
+		if d := duration(r.service.interval(st.service(r.Name).Failures)); d > 0 {
+			r.EffectiveInterval = &d
+		}
+	}
+}
+
+// keepResults keeps the results of services checked less often than
+// mon runs in their state, to report until they are next checked. It
+// is called once results are final, after anomaly detection and
+// remediation.
+func keepResults(results []*result, st *state) {
+	for _, r := range results {
+		ss := st.service(r.Name)
+		ss.Last = nil
+		if r.service.throttled() {
+			last := *r
+			ss.Last = &last
+		}
+	}
+}
+
+// fresh returns the results that aren't stale.
+func fresh(results []*result) []*result {
+	var checked []*result
+	for _, r := range results {
+		if !r.Stale {
+			checked = append(checked, r)
+		}
+	}
+	return checked
+}
+
+// checkHTTP requests the service's URL, recording the response
+// status code in r. The headers and the start of the body of any
+// unsuccessful response are recorded in r's diagnostics.
+func checkHTTP(s *service, _ *serviceState, r *result) {
+	if s.PerAddress {
+		checkAddresses(s, r)
+		return
+	}
+	if s.Preset != "" {
+		checkPreset(s, r)
+		return
+	}
+	status, excerpt, err := get(s, nil)
+	r.Status = status
+	r.State = httpState(status)
+	if err != nil {
+		r.State = stateDown
+		r.Message = err.Error()
+	}
+	var se *schemaError
+	if errors.As(err, &se) {
+		r.Violations = se.violations
+	}
+	if excerpt != nil {
+		r.Diagnostics = &diagnostics{HTTP: excerpt}
+	}
+}
+
+// httpState returns the state of a service responding with the
+// given status code.
+func httpState(status int) string {
+	if status == http.StatusOK {
+		return stateUp
+	}
+	return stateDown
+}
+
+// get requests the service's URL using transport, or the default
+// transport if nil. It returns the response status code, and an
+// excerpt of any unsuccessful response. If the service has a schema,
+// the body of a successful response must validate against it.
+func get(s *service, transport http.RoundTripper) (int, *httpResponse, error) {
+	client := http.Client{
+		Transport: transport,
+		Timeout:   2 * time.Second,
+	}
+	req, err := http.NewRequest(http.MethodGet, s.URL, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	if s.Headers != nil {
+		for k, v := range s.Headers {
+			req.Header.Add(k, v)
+		}
+	}
+	if s.Auth != nil {
+		if err := s.Auth.sign(req, time.Now()); err != nil {
+			return 0, nil, err
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// Server error response OK for now; just need
+		// to indicate a problem.
+		return http.StatusServiceUnavailable, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		if s.Schema == nil {
+			return resp.StatusCode, nil, nil
+		}
+		var v any
+		if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&v); err != nil {
+			return resp.StatusCode, nil, fmt.Errorf("invalid JSON response: %w", err)
+		}
+		return resp.StatusCode, nil, s.Schema.validate(v)
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return resp.StatusCode, &httpResponse{
+		Headers: resp.Header,
+		Body:    string(body),
+	}, nil
+}
+
+// post sends body as JSON to the service's URL, with the service's
+// headers and authentication, and decodes the JSON response into v.
+// It returns the response status code. Responses with unsuccessful
+// status codes are decoded if they contain JSON, as some APIs report
+// errors in them.
+func post(s *service, body, v any) (int, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+	client := http.Client{
+		Timeout: 10 * time.Second,
+	}
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+	if s.Auth != nil {
+		if err := s.Auth.sign(req, time.Now()); err != nil {
+			return 0, err
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return http.StatusServiceUnavailable, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return resp.StatusCode, fmt.Errorf("%s", resp.Status)
+		}
+		return resp.StatusCode, fmt.Errorf("invalid JSON response: %w", err)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxDifferences is the maximum number of differences reported by a
+// comparison.
+const maxDifferences = 20
+
+// compareCheck configures a comparison of the responses of the
+// service's URL, the baseline, and another URL, the candidate, such
+// as a canary deployment. Both are requested with the service's
+// headers and authentication.
+type compareCheck struct {
+	URL string `json:"url"`
+	// Headers are the names of response headers to compare.
+	Headers []string `json:"headers,omitempty"`
+	// Paths select the parts of JSON response bodies to compare. If
+	// empty, whole bodies are compared.
+	Paths []string `json:"paths,omitempty"`
+	// Ignore holds paths of volatile JSON values not to compare.
+	// A "*" path element matches any member or array element, as
+	// in "items.*.updated_at".
+	Ignore []string `json:"ignore,omitempty"`
+	// IgnorePatterns holds regular expressions matching volatile
+	// text, such as timestamps, to remove from bodies before they
+	// are compared.
+	IgnorePatterns []string `json:"ignore_patterns,omitempty"`
+
+	ignore   [][]string
+	patterns []*regexp.Regexp
+}
+
+func (c *compareCheck) init() error {
+	if c.URL == "" {
+		return fmt.Errorf("compare check requires a url")
+	}
+	for _, p := range c.Ignore {
+		c.ignore = append(c.ignore, pathElements(p))
+	}
+	for _, p := range c.IgnorePatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return err
+		}
+		c.patterns = append(c.patterns, re)
+	}
+	return nil
+}
+
+// difference is a way in which the candidate's response differs from
+// the baseline's. It is used for output.
+type difference struct {
+	// Field is "status", "header" followed by the header's name,
+	// "body", or "body" followed by the path of a JSON value or the
+	// number of a line.
+	Field     string `json:"field"`
+	Baseline  string `json:"baseline"`
+	Candidate string `json:"candidate"`
+}
+
+// response is the part of a response compared.
+type response struct {
+	status int
+	header http.Header
+	body   string
+}
+
+// checkCompare requests both URLs concurrently and compares their
+// responses. The service is degraded if they differ, and down if
+// either cannot be requested.
+func checkCompare(s *service, _ *serviceState, r *result) {
+	c := s.Compare
+	var resps [2]*response
+	var errs [2]error
+	var wg sync.WaitGroup
+	for i, u := range []string{s.URL, c.URL} {
+		wg.Add(1)
+		go func(i int, u string) {
+			defer wg.Done()
+			resps[i], errs[i] = fetch(s, u)
+		}(i, u)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			r.State = stateDown
+			r.Message = fmt.Sprintf("%s: %v", []string{"baseline", "candidate"}[i], err)
+			return
+		}
+	}
+	base, cand := resps[0], resps[1]
+	r.Status = base.status
+
+	var diffs []*difference
+	add := func(field string, b, c any) {
+		diffs = append(diffs, &difference{Field: field, Baseline: fmt.Sprint(b), Candidate: fmt.Sprint(c)})
+	}
+	if base.status != cand.status {
+		add("status", base.status, cand.status)
+	}
+	for _, h := range c.Headers {
+		bh := strings.Join(base.header.Values(h), ", ")
+		ch := strings.Join(cand.header.Values(h), ", ")
+		if bh != ch {
+			add("header "+http.CanonicalHeaderKey(h), bh, ch)
+		}
+	}
+	for _, re := range c.patterns {
+		base.body = re.ReplaceAllString(base.body, "")
+		cand.body = re.ReplaceAllString(cand.body, "")
+	}
+	var bv, cv any
+	bErr := json.Unmarshal([]byte(base.body), &bv)
+	cErr := json.Unmarshal([]byte(cand.body), &cv)
+	switch {
+	case base.status != cand.status:
+		// The bodies of responses with different statuses are
+		// not usefully compared.
+	case bErr == nil && cErr == nil && len(c.Paths) > 0:
+		for _, p := range c.Paths {
+			bp, bok := jsonPath(bv, p)
+			cp, cok := jsonPath(cv, p)
+			switch {
+			case !bok && !cok:
+			case !bok || !cok:
+				add(bodyField(pathElements(p)), presence(bp, bok), presence(cp, cok))
+			default:
+				c.diffJSON(pathElements(p), bp, cp, &diffs)
+			}
+		}
+	case bErr == nil && cErr == nil:
+		c.diffJSON(nil, bv, cv, &diffs)
+	case len(c.Paths) > 0:
+		add("body", "not JSON", "not JSON")
+	case base.body != cand.body:
+		diffLines(base.body, cand.body, &diffs)
+	}
+
+	r.State = stateUp
+	if len(diffs) > 0 {
+		r.State = stateDegraded
+		if len(diffs) > maxDifferences {
+			diffs = diffs[:maxDifferences]
+		}
+		r.Differences = diffs
+		msgs := make([]string, 0, 3)
+		for _, d := range diffs[:min(len(diffs), 3)] {
+			msgs = append(msgs, fmt.Sprintf("%s: %s != %s", d.Field, truncate(d.Baseline, 32), truncate(d.Candidate, 32)))
+		}
+		r.Message = "candidate differs; " + strings.Join(msgs, "; ")
+	}
+}
+
+// fetch requests u with the service's headers and authentication.
+func fetch(s *service, u string) (*response, error) {
+	client := http.Client{
+		Timeout: 10 * time.Second,
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range s.Headers {
+		req.Header.Add(k, v)
+	}
+	if s.Auth != nil {
+		if err := s.Auth.sign(req, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	return &response{status: resp.StatusCode, header: resp.Header, body: string(body)}, nil
+}
+
+// diffJSON appends the differences between the JSON values base and
+// cand, found at path, to diffs, skipping ignored paths.
+func (c *compareCheck) diffJSON(path []string, base, cand any, diffs *[]*difference) {
+	for _, ignore := range c.ignore {
+		if matchPath(ignore, path) {
+			return
+		}
+	}
+	if len(*diffs) >= maxDifferences {
+		return
+	}
+	add := func(b, c string) {
+		*diffs = append(*diffs, &difference{Field: bodyField(path), Baseline: b, Candidate: c})
+	}
+	switch b := base.(type) {
+	case map[string]any:
+		m, ok := cand.(map[string]any)
+		if !ok {
+			add(jsonString(base), jsonString(cand))
+			return
+		}
+		keys := make(map[string]bool)
+		for k := range b {
+			keys[k] = true
+		}
+		for k := range m {
+			keys[k] = true
+		}
+		names := make([]string, 0, len(keys))
+		for k := range keys {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			bv, bok := b[k]
+			cv, cok := m[k]
+			if bok && cok {
+				c.diffJSON(append(path[:len(path):len(path)], k), bv, cv, diffs)
+			} else {
+				c.diffMissing(append(path[:len(path):len(path)], k), presence(bv, bok), presence(cv, cok), diffs)
+			}
+		}
+	case []any:
+		a, ok := cand.([]any)
+		if !ok {
+			add(jsonString(base), jsonString(cand))
+			return
+		}
+		if len(b) != len(a) {
+			add(fmt.Sprintf("%d items", len(b)), fmt.Sprintf("%d items", len(a)))
+		}
+		for i := 0; i < min(len(b), len(a)); i++ {
+			c.diffJSON(append(path[:len(path):len(path)], strconv.Itoa(i)), b[i], a[i], diffs)
+		}
+	default:
+		if !reflect.DeepEqual(base, cand) {
+			add(jsonString(base), jsonString(cand))
+		}
+	}
+}
+
+// diffMissing appends a difference for a JSON value at path present
+// in only one response to diffs, unless the path is ignored.
+func (c *compareCheck) diffMissing(path []string, base, cand string, diffs *[]*difference) {
+	for _, ignore := range c.ignore {
+		if matchPath(ignore, path) {
+			return
+		}
+	}
+	if len(*diffs) < maxDifferences {
+		*diffs = append(*diffs, &difference{Field: bodyField(path), Baseline: base, Candidate: cand})
+	}
+}
+
+// diffLines appends the lines that differ between the bodies b and
+// c to diffs.
+func diffLines(b, c string, diffs *[]*difference) {
+	bl, cl := strings.Split(b, "\n"), strings.Split(c, "\n")
+	for i := 0; i < max(len(bl), len(cl)) && len(*diffs) < maxDifferences; i++ {
+		var bs, cs string
+		if i < len(bl) {
+			bs = bl[i]
+		}
+		if i < len(cl) {
+			cs = cl[i]
+		}
+		if bs != cs {
+			*diffs = append(*diffs, &difference{
+				Field:     fmt.Sprintf("body line %d", i+1),
+				Baseline:  truncate(bs, 200),
+				Candidate: truncate(cs, 200),
+			})
+		}
+	}
+}
+
+// presence returns the JSON encoding of v if ok, or "missing".
+func presence(v any, ok bool) string {
+	if !ok {
+		return "missing"
+	}
+	return jsonString(v)
+}
+
+// matchPath reports whether path is at or below pattern, in which
+// "*" elements match any element.
+func matchPath(pattern, path []string) bool {
+	if len(path) < len(pattern) {
+		return false
+	}
+	for i, p := range pattern {
+		if p != "*" && p != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// bodyField returns the difference field for the JSON value at path.
+func bodyField(path []string) string {
+	var b strings.Builder
+	b.WriteString("body")
+	for _, p := range path {
+		if _, err := strconv.Atoi(p); err == nil {
+			fmt.Fprintf(&b, "[%s]", p)
+		} else {
+			b.WriteString("." + p)
+		}
+	}
+	return b.String()
+}
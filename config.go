@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// config represents the contents of the services file.
+type config struct {
+	Services  []*service           `json:"services"`
+	Notifiers map[string]*notifier `json:"notifiers,omitempty"`
+	Route     *route               `json:"route,omitempty"`
+
+	Diagnostics *diagnosticsConfig `json:"diagnostics,omitempty"`
+}
+
+// service represents a service definition from the configuration file.
+type service struct {
+	Name string `json:"name"`
+	// Type is the type of check made of the service, "http" by
+	// default. Checks other than HTTP are configured by the property
+	// named after their type.
+	Type     string            `json:"type,omitempty"`
+	URL      string            `json:"url"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Auth     *authConfig       `json:"auth,omitempty"`
+	Schema   *schemaConfig     `json:"schema,omitempty"`
+	Tags     []string          `json:"tags,omitempty"`
+	Owner    string            `json:"owner,omitempty"`
+	Severity string            `json:"severity,omitempty"`
+
+	// Preset names a well-known service whose health endpoint the
+	// URL refers to, so its response is interpreted accordingly.
+	Preset string `json:"preset,omitempty"`
+
+	// PerAddress checks each address the URL's host resolves to
+	// separately, combining their states according to AddressPolicy.
+	PerAddress    bool   `json:"per_address,omitempty"`
+	AddressPolicy string `json:"address_policy,omitempty"`
+
+	// Schedule restricts checks of the service to the times it
+	// matches, and ActiveHours restricts alerts of its failures, both
+	// in Timezone, the local time zone by default. Between scheduled
+	// checks, the result of the last check is reported.
+	Schedule    *schedule `json:"schedule,omitempty"`
+	ActiveHours *schedule `json:"active_hours,omitempty"`
+	Timezone    string    `json:"timezone,omitempty"`
+
+	// Interval is the minimum time between checks of the service.
+	// While it is down, it is checked after DownInterval instead,
+	// doubling with each further down check up to MaxDownInterval.
+	// Without MaxDownInterval, it doubles up to Interval, if longer.
+	Interval        *duration `json:"interval,omitempty"`
+	DownInterval    *duration `json:"down_interval,omitempty"`
+	MaxDownInterval *duration `json:"max_down_interval,omitempty"`
+
+	Host       *hostCheck       `json:"host,omitempty"`
+	Process    *processCheck    `json:"process,omitempty"`
+	Systemd    *systemdCheck    `json:"systemd,omitempty"`
+	Logfile    *logfileCheck    `json:"logfile,omitempty"`
+	Mailflow   *mailflowCheck   `json:"mailflow,omitempty"`
+	Domain     *domainCheck     `json:"domain,omitempty"`
+	DNSBL      *dnsblCheck      `json:"dnsbl,omitempty"`
+	S3         *s3Check         `json:"s3,omitempty"`
+	LDAP       *ldapCheck       `json:"ldap,omitempty"`
+	AMQP       *amqpCheck       `json:"amqp,omitempty"`
+	NATS       *natsCheck       `json:"nats,omitempty"`
+	GraphQL    *graphqlCheck    `json:"graphql,omitempty"`
+	JSONRPC    *jsonrpcCheck    `json:"jsonrpc,omitempty"`
+	Compare    *compareCheck    `json:"compare,omitempty"`
+	StatusPage *statuspageCheck `json:"statuspage,omitempty"`
+
+	SLOs    []*slo   `json:"slos,omitempty"`
+	Anomaly *anomaly `json:"anomaly,omitempty"`
+	OnDown  *onDown  `json:"on_down,omitempty"`
+
+	loc *time.Location
+}
+
+// parseConfig parses the contents of a services file in the directory
+// dir, against which relative paths in the file are resolved. The
+// file may either be an object containing services, notifiers and
+// routes, or a bare array of services.
+func parseConfig(data []byte, dir string) (*config, error) {
+	cfg := &config{}
+	var err error
+	if b := bytes.TrimSpace(data); len(b) > 0 && b[0] == '[' {
+		err = json.Unmarshal(data, &cfg.Services)
+	} else {
+		err = json.Unmarshal(data, cfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Without any configured notifiers, failing services are sent
+	// to a desktop notification, one per service.
+	if len(cfg.Notifiers) == 0 {
+		cfg.Notifiers = map[string]*notifier{
+			"desktop": {Type: "osascript"},
+		}
+	}
+	if cfg.Route == nil {
+		cfg.Route = &route{}
+	}
+	if cfg.Route.Notifiers == nil {
+		for name := range cfg.Notifiers {
+			cfg.Route.Notifiers = append(cfg.Route.Notifiers, name)
+		}
+		sort.Strings(cfg.Route.Notifiers)
+	}
+	if cfg.Route.GroupBy == nil {
+		cfg.Route.GroupBy = []string{"service"}
+	}
+
+	names := make(map[string]bool)
+	for _, s := range cfg.Services {
+		if s.Name == "" {
+			return nil, fmt.Errorf("service with URL %q has no name", s.URL)
+		}
+		if names[s.Name] {
+			return nil, fmt.Errorf("duplicate service name %q", s.Name)
+		}
+		names[s.Name] = true
+		if err := s.validate(); err != nil {
+			return nil, fmt.Errorf("service %q: %w", s.Name, err)
+		}
+		switch s.AddressPolicy {
+		case "":
+			s.AddressPolicy = "all"
+		case "all", "any", "majority":
+		default:
+			return nil, fmt.Errorf("service %q: unknown address policy %q", s.Name, s.AddressPolicy)
+		}
+		s.loc = time.Local
+		if s.Timezone != "" {
+			if s.loc, err = time.LoadLocation(s.Timezone); err != nil {
+				return nil, fmt.Errorf("service %q: %w", s.Name, err)
+			}
+		}
+		if err := s.initIntervals(); err != nil {
+			return nil, fmt.Errorf("service %q: %w", s.Name, err)
+		}
+		if s.Auth != nil {
+			if err := s.Auth.init(); err != nil {
+				return nil, fmt.Errorf("service %q: %w", s.Name, err)
+			}
+		}
+		if s.Schema != nil {
+			if err := s.Schema.init(dir); err != nil {
+				return nil, fmt.Errorf("service %q: %w", s.Name, err)
+			}
+		}
+		for _, o := range s.SLOs {
+			if err := o.init(); err != nil {
+				return nil, fmt.Errorf("service %q: %w", s.Name, err)
+			}
+		}
+		if s.Anomaly != nil {
+			if err := s.Anomaly.init(); err != nil {
+				return nil, fmt.Errorf("service %q: %w", s.Name, err)
+			}
+		}
+		if s.OnDown != nil {
+			if err := s.OnDown.init(); err != nil {
+				return nil, fmt.Errorf("service %q: %w", s.Name, err)
+			}
+		}
+	}
+	for name, n := range cfg.Notifiers {
+		if err := n.validate(); err != nil {
+			return nil, fmt.Errorf("notifier %q: %w", name, err)
+		}
+	}
+	if err := cfg.Route.init(nil, "0", cfg.Notifiers); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// intervalSlack allows for mon running slightly early relative to
+// the intervals of services.
+const intervalSlack = time.Second
+
+func (s *service) initIntervals() error {
+	for _, d := range []*duration{s.Interval, s.DownInterval, s.MaxDownInterval} {
+		if d != nil && *d <= 0 {
+			return fmt.Errorf("intervals must be positive")
+		}
+	}
+	if s.DownInterval == nil {
+		return nil
+	}
+	switch {
+	case s.MaxDownInterval != nil:
+		if *s.MaxDownInterval < *s.DownInterval {
+			return fmt.Errorf("max_down_interval is less than down_interval")
+		}
+	case s.Interval != nil:
+		d := max(*s.Interval, *s.DownInterval)
+		s.MaxDownInterval = &d
+	}
+	return nil
+}
+
+// throttled reports whether the service is checked less often than
+// mon runs.
+func (s *service) throttled() bool {
+	return s.Schedule != nil || s.Interval != nil || s.DownInterval != nil
+}
+
+// interval returns the minimum time between checks of the service,
+// having been down for the given number of consecutive checks, or
+// zero if there is none.
+func (s *service) interval(failures int) time.Duration {
+	if failures == 0 || s.DownInterval == nil {
+		if s.Interval == nil {
+			return 0
+		}
+		return time.Duration(*s.Interval)
+	}
+	d := time.Duration(*s.DownInterval)
+	if s.MaxDownInterval == nil {
+		return d
+	}
+	max := time.Duration(*s.MaxDownInterval)
+	for i := 1; i < failures && d < max; i++ {
+		d *= 2
+	}
+	return min(d, max)
+}
+
+// due reports whether the service is due to be checked at now. It is
+// unless it has a schedule that hasn't matched since its last check,
+// or its interval hasn't passed since then.
+func (s *service) due(ss *serviceState, now time.Time) bool {
+	if !s.throttled() || ss.Last == nil {
+		return true
+	}
+	if s.Schedule != nil {
+		next := s.Schedule.next(ss.Checked, s.loc)
+		if next.IsZero() || next.After(now) {
+			return false
+		}
+	}
+	return now.Sub(ss.Checked) >= s.interval(ss.Failures)-intervalSlack
+}
+
+// active reports whether failures of the service are alerted at now.
+func (s *service) active(now time.Time) bool {
+	return s.ActiveHours == nil || s.ActiveHours.matches(now.In(s.loc))
+}
+
+// validate checks the service has the configuration required by its
+// type.
+func (s *service) validate() error {
+	if s.Type == "" {
+		s.Type = "http"
+	}
+	if checkers[s.Type] == nil {
+		return fmt.Errorf("unknown type %q", s.Type)
+	}
+	var ok bool
+	switch s.Type {
+	case "http":
+		if ok = s.URL != ""; ok && s.Preset != "" {
+			return s.initPreset()
+		}
+	case "host":
+		ok = s.Host != nil
+	case "process":
+		if ok = s.Process != nil; ok {
+			return s.Process.init()
+		}
+	case "systemd":
+		ok = s.Systemd != nil && s.Systemd.Unit != ""
+	case "logfile":
+		if ok = s.Logfile != nil; ok {
+			return s.Logfile.init()
+		}
+	case "mailflow":
+		if ok = s.Mailflow != nil; ok {
+			return s.Mailflow.init()
+		}
+	case "domain":
+		if ok = s.Domain != nil; ok {
+			return s.Domain.init()
+		}
+	case "dnsbl":
+		if ok = s.DNSBL != nil; ok {
+			return s.DNSBL.init()
+		}
+	case "s3":
+		if ok = s.S3 != nil; ok {
+			return s.S3.init()
+		}
+	case "ldap":
+		if ok = s.LDAP != nil; ok {
+			return s.LDAP.init()
+		}
+	case "amqp":
+		if ok = s.AMQP != nil; ok {
+			return s.AMQP.init()
+		}
+	case "nats":
+		if ok = s.NATS != nil; ok {
+			return s.NATS.init()
+		}
+	case "graphql":
+		if ok = s.URL != "" && s.GraphQL != nil; ok {
+			return s.GraphQL.init()
+		}
+	case "jsonrpc":
+		if ok = s.URL != "" && s.JSONRPC != nil; ok {
+			return s.JSONRPC.init()
+		}
+	case "compare":
+		if ok = s.URL != "" && s.Compare != nil; ok {
+			return s.Compare.init()
+		}
+	case "statuspage":
+		// Without configuration, every component is checked.
+		if ok = s.URL != ""; ok {
+			var err error
+			if s.URL, err = summaryURL(s.URL); err != nil {
+				return err
+			}
+			if s.StatusPage == nil {
+				s.StatusPage = &statuspageCheck{}
+			}
+			return s.StatusPage.init()
+		}
+	}
+	if !ok {
+		return fmt.Errorf("missing configuration for %s check", s.Type)
+	}
+	return nil
+}
+
+// duration is a time.Duration represented in JSON as a string
+// such as "30s", "4h" or "30d".
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := parseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(formatDuration(time.Duration(d)))
+}
+
+// parseDuration is time.ParseDuration, additionally accepting a
+// whole number of days such as "30d".
+func parseDuration(s string) (time.Duration, error) {
+	if n, ok := strings.CutSuffix(s, "d"); ok {
+		days, err := strconv.Atoi(n)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// formatDuration formats d as a number of days if it is a whole
+// number of days, otherwise as time.Duration.String does.
+func formatDuration(d time.Duration) string {
+	day := 24 * time.Hour
+	if d >= day && d%day == 0 {
+		return fmt.Sprintf("%dd", d/day)
+	}
+	return d.String()
+}
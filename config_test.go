@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// intervalService returns a service configured with the given
+// intervals, as JSON object members.
+func intervalService(t *testing.T, intervals string) *service {
+	t.Helper()
+	sep := ""
+	if intervals != "" {
+		sep = ", "
+	}
+	cfg := newFakeNotifiers(t).config(t, `{}`, fmt.Sprintf(
+		`{"name": "api", "url": "http://api.invalid/", "timezone": "UTC"%s%s}`, sep, intervals))
+	return cfg.Services[0]
+}
+
+func TestServiceInterval(t *testing.T) {
+	tests := []struct {
+		intervals string
+		want      []time.Duration // after 0, 1, 2... failures
+	}{
+		{``, []time.Duration{0, 0, 0}},
+		{`"interval": "5m"`, []time.Duration{5 * time.Minute, 5 * time.Minute, 5 * time.Minute}},
+		// Without a longer interval, the down interval doesn't back
+		// off.
+		{`"down_interval": "1m"`, []time.Duration{0, time.Minute, time.Minute, time.Minute}},
+		{`"interval": "30s", "down_interval": "1m"`, []time.Duration{
+			30 * time.Second, time.Minute, time.Minute,
+		}},
+		// By default the down interval doubles up to the interval.
+		{`"interval": "10m", "down_interval": "1m"`, []time.Duration{
+			10 * time.Minute, time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute,
+		}},
+		{`"down_interval": "1m", "max_down_interval": "3m"`, []time.Duration{
+			0, time.Minute, 2 * time.Minute, 3 * time.Minute, 3 * time.Minute,
+		}},
+		{`"interval": "5m", "down_interval": "1m", "max_down_interval": "1h"`, []time.Duration{
+			5 * time.Minute, time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute,
+			16 * time.Minute, 32 * time.Minute, time.Hour, time.Hour,
+		}},
+	}
+	for _, tt := range tests {
+		s := intervalService(t, tt.intervals)
+		for failures, want := range tt.want {
+			if got := s.interval(failures); got != want {
+				t.Errorf("{%s}: interval after %d failures = %s, want %s", tt.intervals, failures, got, want)
+			}
+		}
+		// Backing off doesn't overflow after many failures.
+		if got, want := s.interval(1000), tt.want[len(tt.want)-1]; got != want {
+			t.Errorf("{%s}: interval after 1000 failures = %s, want %s", tt.intervals, got, want)
+		}
+	}
+
+	for _, intervals := range []string{
+		`"interval": "0s"`,
+		`"down_interval": "-1m"`,
+		`"down_interval": "5m", "max_down_interval": "1m"`,
+	} {
+		data := fmt.Sprintf(`{"services": [{"name": "api", "url": "http://api.invalid/", %s}]}`, intervals)
+		if _, err := parseConfig([]byte(data), ""); err == nil {
+			t.Errorf("{%s} parsed, want error", intervals)
+		}
+	}
+}
+
+func TestServiceDue(t *testing.T) {
+	checked := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		intervals string
+		failures  int
+		last      bool
+		after     time.Duration
+		want      bool
+	}{
+		{``, 0, true, 0, true},
+		// Services are due if they have no previous result.
+		{`"interval": "5m"`, 0, false, 0, true},
+		{`"interval": "5m"`, 0, true, 4*time.Minute + 58*time.Second, false},
+		// mon running up to a second early is allowed for.
+		{`"interval": "5m"`, 0, true, 4*time.Minute + 59*time.Second, true},
+		{`"interval": "5m"`, 0, true, 5 * time.Minute, true},
+		// Down services are checked after their backed off down
+		// interval.
+		{`"interval": "10m", "down_interval": "1m"`, 1, true, time.Minute, true},
+		{`"interval": "10m", "down_interval": "1m"`, 3, true, 3 * time.Minute, false},
+		{`"interval": "10m", "down_interval": "1m"`, 3, true, 4 * time.Minute, true},
+		{`"interval": "10m", "down_interval": "1m"`, 9, true, 9 * time.Minute, false},
+		{`"interval": "10m", "down_interval": "1m"`, 9, true, 10 * time.Minute, true},
+		// Scheduled services are due once the schedule has matched
+		// since their last check, and their interval has passed.
+		{`"schedule": "*/15 * * * *"`, 0, true, 14 * time.Minute, false},
+		{`"schedule": "*/15 * * * *"`, 0, true, 15 * time.Minute, true},
+		{`"schedule": "*/15 * * * *", "interval": "20m"`, 0, true, 15 * time.Minute, false},
+		{`"schedule": "*/15 * * * *", "interval": "20m"`, 0, true, 20 * time.Minute, true},
+	}
+	for _, tt := range tests {
+		s := intervalService(t, tt.intervals)
+		ss := &serviceState{Checked: checked, Failures: tt.failures}
+		if tt.last {
+			ss.Last = &result{Name: "api"}
+		}
+		if got := s.due(ss, checked.Add(tt.after)); got != tt.want {
+			t.Errorf("{%s} after %d failures, checked %s ago: due = %t, want %t",
+				tt.intervals, tt.failures, tt.after, got, tt.want)
+		}
+	}
+}
+
+func TestCountFailures(t *testing.T) {
+	s := intervalService(t, `"interval": "10m", "down_interval": "1m"`)
+	st := &state{Services: make(map[string]*serviceState)}
+	for _, step := range []struct {
+		state    string
+		failures int
+		interval time.Duration
+	}{
+		{stateDown, 1, time.Minute},
+		{stateDown, 2, 2 * time.Minute},
+		{stateDown, 3, 4 * time.Minute},
+		{stateDegraded, 0, 10 * time.Minute},
+		{stateDown, 1, time.Minute},
+		{stateUp, 0, 10 * time.Minute},
+	} {
+		results := []*result{{Name: "api", State: step.state, service: s}}
+		countFailures(results, st)
+		setIntervals(results, st)
+		if got := st.service("api").Failures; got != step.failures {
+			t.Errorf("%s: %d failures, want %d", step.state, got, step.failures)
+		}
+		if got := results[0].EffectiveInterval; got == nil || time.Duration(*got) != step.interval {
+			t.Errorf("%s after %d failures: effective interval %v, want %s", step.state, step.failures, got, step.interval)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// diagnosticsTimeout is the time allowed for each diagnostic probe.
+const diagnosticsTimeout = 2 * time.Second
+
+// diagnosticsConfig configures the gathering of diagnostics for
+// failing services.
+type diagnosticsConfig struct {
+	// Resolvers are the addresses of DNS servers, such as
+	// "1.1.1.1:53", queried in addition to the system resolver.
+	Resolvers []string `json:"resolvers,omitempty"`
+}
+
+// diagnostics holds context gathered when a service fails. It is
+// used for output.
+type diagnostics struct {
+	DNS      []*dnsAnswer  `json:"dns,omitempty"`
+	TCP      []*tcpResult  `json:"tcp,omitempty"`
+	TLS      *tlsSummary   `json:"tls,omitempty"`
+	HTTP     *httpResponse `json:"http,omitempty"`
+	Siblings []*sibling    `json:"siblings,omitempty"`
+}
+
+// dnsAnswer holds the addresses a resolver returned for a host.
+type dnsAnswer struct {
+	Resolver  string   `json:"resolver"`
+	Addresses []string `json:"addresses,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// tcpResult holds the outcome of connecting to an address.
+type tcpResult struct {
+	Address string   `json:"address"`
+	Latency duration `json:"latency"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// tlsSummary describes a TLS handshake.
+type tlsSummary struct {
+	Version     string    `json:"version,omitempty"`
+	CipherSuite string    `json:"cipher_suite,omitempty"`
+	Subject     string    `json:"subject,omitempty"`
+	Issuer      string    `json:"issuer,omitempty"`
+	NotAfter    time.Time `json:"not_after,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// httpResponse holds an excerpt of an unsuccessful HTTP response.
+type httpResponse struct {
+	Headers map[string][]string `json:"headers"`
+	Body    string              `json:"body,omitempty"`
+}
+
+// sibling is another service on the same host as a failing service.
+type sibling struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+// newResolver returns a resolver that queries the DNS server at
+// address, or the system resolver if address is empty.
+func newResolver(address string) *net.Resolver {
+	if address == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, address)
+		},
+	}
+}
+
+// diagnose gathers diagnostics for down services with URLs, adding
+// them to any already recorded by their checks.
+func diagnose(cfg *diagnosticsConfig, results []*result) {
+	var wg sync.WaitGroup
+	for _, r := range results {
+		if r.State != stateDown {
+			continue
+		}
+		u, err := url.Parse(r.URL)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		if r.Diagnostics == nil {
+			r.Diagnostics = &diagnostics{}
+		}
+		for _, other := range results {
+			if other == r {
+				continue
+			}
+			if ou, err := url.Parse(other.URL); err == nil && ou.Hostname() == u.Hostname() {
+				r.Diagnostics.Siblings = append(r.Diagnostics.Siblings, &sibling{
+					Name:  other.Name,
+					State: other.State,
+				})
+			}
+		}
+		wg.Add(1)
+		go func(r *result, u *url.URL) {
+			defer wg.Done()
+			r.Diagnostics.probe(cfg, u)
+		}(r, u)
+	}
+	wg.Wait()
+}
+
+// probe resolves the host of u with each resolver, then connects to
+// every address found, performing a TLS handshake with the first
+// reachable address if u is an HTTPS URL.
+func (d *diagnostics) probe(cfg *diagnosticsConfig, u *url.URL) {
+	host := u.Hostname()
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	resolvers := []string{""}
+	if cfg != nil {
+		resolvers = append(resolvers, cfg.Resolvers...)
+	}
+	var addrs []string
+	seen := make(map[string]bool)
+	for _, server := range resolvers {
+		ans := &dnsAnswer{Resolver: server}
+		if server == "" {
+			ans.Resolver = "system"
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), diagnosticsTimeout)
+		ips, err := newResolver(server).LookupHost(ctx, host)
+		cancel()
+		if err != nil {
+			ans.Error = err.Error()
+		}
+		ans.Addresses = ips
+		d.DNS = append(d.DNS, ans)
+		for _, ip := range ips {
+			if !seen[ip] {
+				seen[ip] = true
+				addrs = append(addrs, ip)
+			}
+		}
+	}
+
+	var reachable string
+	for _, ip := range addrs {
+		addr := net.JoinHostPort(ip, port)
+		tr := &tcpResult{Address: addr}
+		start := time.Now()
+		conn, err := net.DialTimeout("tcp", addr, diagnosticsTimeout)
+		tr.Latency = duration(time.Since(start))
+		if err != nil {
+			tr.Error = err.Error()
+		} else {
+			conn.Close()
+			if reachable == "" {
+				reachable = addr
+			}
+		}
+		d.TCP = append(d.TCP, tr)
+	}
+
+	if u.Scheme == "https" && reachable != "" {
+		d.TLS = handshake(reachable, host)
+	}
+}
+
+// handshake performs a TLS handshake with the server at addr,
+// summarising the result. Verification errors are reported, but do
+// not prevent the certificate from being described.
+func handshake(addr, serverName string) *tlsSummary {
+	ts := &tlsSummary{}
+	dialer := &net.Dialer{Timeout: diagnosticsTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		ts.Error = err.Error()
+		return ts
+	}
+	defer conn.Close()
+
+	cs := conn.ConnectionState()
+	ts.Version = tls.VersionName(cs.Version)
+	ts.CipherSuite = tls.CipherSuiteName(cs.CipherSuite)
+	if len(cs.PeerCertificates) == 0 {
+		return ts
+	}
+	cert := cs.PeerCertificates[0]
+	ts.Subject = cert.Subject.String()
+	ts.Issuer = cert.Issuer.String()
+	ts.NotAfter = cert.NotAfter
+
+	opts := x509.VerifyOptions{
+		DNSName:       serverName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, c := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	if _, err := cert.Verify(opts); err != nil {
+		ts.Error = err.Error()
+	}
+	return ts
+}
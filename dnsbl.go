@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dnsblCheck configures a check of whether a host's addresses are
+// listed on DNS blocklists.
+type dnsblCheck struct {
+	// Host is resolved to find the addresses to check, unless IP is
+	// given.
+	Host  string   `json:"host,omitempty"`
+	IP    string   `json:"ip,omitempty"`
+	Zones []string `json:"zones"`
+	// Resolver is the address of the DNS server to query, such as
+	// "127.0.0.1:53". The system resolver is used by default.
+	Resolver string `json:"resolver,omitempty"`
+}
+
+func (d *dnsblCheck) init() error {
+	if (d.Host == "") == (d.IP == "") {
+		return fmt.Errorf("dnsbl check requires one of host or ip")
+	}
+	if d.IP != "" && net.ParseIP(d.IP) == nil {
+		return fmt.Errorf("invalid ip %q", d.IP)
+	}
+	if len(d.Zones) == 0 {
+		return fmt.Errorf("dnsbl check requires zones")
+	}
+	return nil
+}
+
+// listing is an address's entry on a blocklist. It is used for
+// output.
+type listing struct {
+	IP     string   `json:"ip"`
+	Zone   string   `json:"zone"`
+	Codes  []string `json:"codes"`
+	Reason string   `json:"reason,omitempty"`
+}
+
+// checkDNSBL looks up each of the host's addresses on each zone. The
+// service is degraded if any address is listed, and down if none of
+// the lookups succeed.
+func checkDNSBL(s *service, _ *serviceState, r *result) {
+	d := s.DNSBL
+	resolver := newResolver(d.Resolver)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ips := []string{d.IP}
+	if d.Host != "" {
+		var err error
+		if ips, err = resolver.LookupHost(ctx, d.Host); err != nil {
+			r.State = stateDown
+			r.Message = err.Error()
+			return
+		}
+	}
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		errs   []string
+		failed int
+	)
+	for _, ip := range ips {
+		for _, zone := range d.Zones {
+			wg.Add(1)
+			go func(ip, zone string) {
+				defer wg.Done()
+				l, err := lookupListing(ctx, resolver, ip, zone)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					failed++
+					errs = append(errs, fmt.Sprintf("%s: %v", zone, err))
+					return
+				}
+				if l != nil {
+					r.Listings = append(r.Listings, l)
+				}
+			}(ip, zone)
+		}
+	}
+	wg.Wait()
+
+	var listed []string
+	for _, l := range r.Listings {
+		listed = append(listed, fmt.Sprintf("%s listed on %s (%s)", l.IP, l.Zone, strings.Join(l.Codes, ", ")))
+	}
+	switch {
+	case failed == len(ips)*len(d.Zones):
+		r.State = stateDown
+	case len(listed) > 0:
+		r.State = stateDegraded
+	default:
+		r.State = stateUp
+	}
+	r.Message = strings.Join(append(listed, errs...), "; ")
+}
+
+// lookupListing queries zone for ip, returning nil if it is not
+// listed.
+func lookupListing(ctx context.Context, resolver *net.Resolver, ip, zone string) (*listing, error) {
+	name, err := reverseName(ip)
+	if err != nil {
+		return nil, err
+	}
+	name += "." + strings.TrimSuffix(zone, ".")
+	codes, err := resolver.LookupHost(ctx, name)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Some lists answer 127.255.255.0/24 to report errors, such as
+	// queries from public resolvers they refuse to serve.
+	for _, c := range codes {
+		if strings.HasPrefix(c, "127.255.255.") {
+			return nil, fmt.Errorf("query refused with %s", c)
+		}
+	}
+	l := &listing{IP: ip, Zone: zone, Codes: codes}
+	if txt, err := resolver.LookupTXT(ctx, name); err == nil {
+		l.Reason = strings.Join(txt, " ")
+	}
+	return l, nil
+}
+
+// reverseName returns ip in the reversed form used for DNSBL and
+// reverse DNS queries, without a zone.
+func reverseName(ip string) (string, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return "", fmt.Errorf("invalid ip %q", ip)
+	}
+	var labels []string
+	if v4 := addr.To4(); v4 != nil {
+		for i := len(v4) - 1; i >= 0; i-- {
+			labels = append(labels, fmt.Sprint(v4[i]))
+		}
+		return strings.Join(labels, "."), nil
+	}
+	const hex = "0123456789abcdef"
+	for i := len(addr) - 1; i >= 0; i-- {
+		labels = append(labels, string(hex[addr[i]&0xf]), string(hex[addr[i]>>4]))
+	}
+	return strings.Join(labels, "."), nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// rdapBootstrapURL is the IANA registry of RDAP services for top
+// level domains.
+var rdapBootstrapURL = "https://data.iana.org/rdap/dns.json"
+
+const (
+	// rdapCacheTime is how long a domain's registration is reused
+	// before being looked up again.
+	rdapCacheTime = 24 * time.Hour
+	// rdapRetryTime is how long after a failed lookup it is retried.
+	rdapRetryTime = time.Hour
+)
+
+// domainCheck configures a check of a domain's registration via RDAP.
+type domainCheck struct {
+	Name string `json:"name"`
+	// WarnDays and CriticalDays are the numbers of days before
+	// expiry at which the service is degraded (default 30) or down
+	// (default 7).
+	WarnDays     int `json:"warn_days,omitempty"`
+	CriticalDays int `json:"critical_days,omitempty"`
+	// RDAPURL is the base URL of the RDAP service to query, found
+	// from the IANA bootstrap registry by default.
+	RDAPURL string `json:"rdap_url,omitempty"`
+}
+
+func (d *domainCheck) init() error {
+	if d.Name == "" {
+		return fmt.Errorf("domain check requires a name")
+	}
+	if d.WarnDays == 0 {
+		d.WarnDays = 30
+	}
+	if d.CriticalDays == 0 {
+		d.CriticalDays = 7
+	}
+	return nil
+}
+
+// domainInfo holds a domain's registration details. It is used for
+// output.
+type domainInfo struct {
+	Registrar string    `json:"registrar,omitempty"`
+	Expires   time.Time `json:"expires"`
+	Status    []string  `json:"status,omitempty"`
+	DaysLeft  int       `json:"days_left"`
+	Fetched   time.Time `json:"fetched"`
+}
+
+// rdapState is the persisted state of a domain's RDAP lookups.
+type rdapState struct {
+	// Base is the base URL of the domain's RDAP service, found from
+	// the IANA bootstrap registry.
+	Base string `json:"base,omitempty"`
+	// Failed is when a lookup last failed, and Error why.
+	Failed time.Time `json:"failed"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// inactiveStatuses are RDAP status values for domains that no longer
+// resolve, or are about to be deleted.
+var inactiveStatuses = []string{"client hold", "server hold", "redemption period", "pending delete", "inactive"}
+
+// checkDomain looks up the domain's registration, reusing lookups
+// made within the last day, and retrying failed lookups after an
+// hour. The service is degraded or down as the domain nears expiry,
+// and down if its status shows it is inactive.
+func checkDomain(s *service, ss *serviceState, r *result) {
+	d := s.Domain
+	now := time.Now()
+	if ss.RDAP == nil {
+		ss.RDAP = &rdapState{}
+	}
+	rs := ss.RDAP
+	info := ss.Domain
+	if (info == nil || now.Sub(info.Fetched) > rdapCacheTime) && now.Sub(rs.Failed) > rdapRetryTime {
+		fetched, err := d.lookup(rs)
+		if err == nil {
+			fetched.Fetched = now
+			info = fetched
+			ss.Domain = info
+			rs.Failed, rs.Error = time.Time{}, ""
+		} else {
+			rs.Failed, rs.Error = now, err.Error()
+			if info != nil {
+				logger.Error("unable to refresh domain registration, using previous lookup",
+					"domain", d.Name,
+					"error", err)
+			}
+		}
+	}
+	if info == nil {
+		r.State = stateDown
+		r.Message = fmt.Sprintf("rdap: %s", rs.Error)
+		return
+	}
+
+	out := *info
+	out.DaysLeft = int(info.Expires.Sub(now).Hours() / 24)
+	r.Domain = &out
+	r.State = stateUp
+	for _, st := range info.Status {
+		for _, inactive := range inactiveStatuses {
+			if st == inactive {
+				r.State = stateDown
+				r.Message = fmt.Sprintf("domain status is %s", st)
+				return
+			}
+		}
+	}
+	switch {
+	case info.Expires.IsZero():
+		r.State = stateDegraded
+		r.Message = "expiry date not published"
+	case out.DaysLeft <= d.CriticalDays:
+		r.State = stateDown
+	case out.DaysLeft <= d.WarnDays:
+		r.State = stateDegraded
+	}
+	if r.State != stateUp && r.Message == "" {
+		r.Message = fmt.Sprintf("domain expires in %d days, on %s", out.DaysLeft, info.Expires.Format("2 Jan 2006"))
+	}
+}
+
+// lookup queries RDAP for the domain's registration. The base URL of
+// the RDAP service found from the bootstrap registry is kept in rs
+// until a lookup fails.
+func (d *domainCheck) lookup(rs *rdapState) (*domainInfo, error) {
+	base := d.RDAPURL
+	if base == "" {
+		if rs.Base == "" {
+			var err error
+			if rs.Base, err = rdapBase(d.Name); err != nil {
+				return nil, err
+			}
+		}
+		base = rs.Base
+	}
+	var resp struct {
+		Events []struct {
+			Action string    `json:"eventAction"`
+			Date   time.Time `json:"eventDate"`
+		} `json:"events"`
+		Entities []struct {
+			Roles []string          `json:"roles"`
+			VCard []json.RawMessage `json:"vcardArray"`
+		} `json:"entities"`
+		Status []string `json:"status"`
+	}
+	url := strings.TrimSuffix(base, "/") + "/domain/" + d.Name
+	if err := getJSON(url, "application/rdap+json", &resp); err != nil {
+		// The domain's RDAP service may have moved.
+		rs.Base = ""
+		return nil, err
+	}
+
+	info := &domainInfo{Status: resp.Status}
+	for _, e := range resp.Events {
+		if e.Action == "expiration" {
+			info.Expires = e.Date
+		}
+	}
+	for _, e := range resp.Entities {
+		for _, role := range e.Roles {
+			if role == "registrar" && len(e.VCard) == 2 {
+				info.Registrar = vcardName(e.VCard[1])
+			}
+		}
+	}
+	return info, nil
+}
+
+// vcardName returns the formatted name from a jCard's properties.
+func vcardName(props json.RawMessage) string {
+	var ps [][]any
+	if err := json.Unmarshal(props, &ps); err != nil {
+		return ""
+	}
+	for _, p := range ps {
+		if len(p) == 4 && p[0] == "fn" {
+			if name, ok := p[3].(string); ok {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// rdapBase returns the base URL of the RDAP service for the domain's
+// top level domain, from the IANA bootstrap registry.
+func rdapBase(domain string) (string, error) {
+	var bootstrap struct {
+		Services [][][]string `json:"services"`
+	}
+	if err := getJSON(rdapBootstrapURL, "application/json", &bootstrap); err != nil {
+		return "", err
+	}
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(domain, ".")), ".")
+	// Prefer the longest matching suffix.
+	for i := 1; i < len(labels); i++ {
+		suffix := strings.Join(labels[i:], ".")
+		for _, svc := range bootstrap.Services {
+			if len(svc) != 2 || len(svc[1]) == 0 {
+				continue
+			}
+			for _, tld := range svc[0] {
+				if tld == suffix {
+					return svc[1][0], nil
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("no RDAP service found for %s", domain)
+}
+
+// getJSON requests url, decoding the JSON response into v.
+func getJSON(url, accept string, v any) error {
+	client := http.Client{
+		Timeout: 10 * time.Second,
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", accept)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeRDAP is a fake RDAP service, counting the lookups made of it.
+type fakeRDAP struct {
+	expires time.Time
+	status  []string
+	// fail is the status code returned, if set.
+	fail    int
+	lookups atomic.Int32
+}
+
+func (f *fakeRDAP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lookups.Add(1)
+	if f.fail != 0 {
+		w.WriteHeader(f.fail)
+		return
+	}
+	if r.URL.Path != "/domain/example.com" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/rdap+json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status": f.status,
+		"events": []map[string]any{
+			{"eventAction": "registration", "eventDate": "1995-08-14T04:00:00Z"},
+			{"eventAction": "expiration", "eventDate": f.expires.Format(time.RFC3339)},
+		},
+		"entities": []map[string]any{{
+			"roles":      []string{"registrar"},
+			"vcardArray": []any{"vcard", [][]any{{"version", map[string]any{}, "text", "4.0"}, {"fn", map[string]any{}, "text", "Example Registrar"}}},
+		}},
+	})
+}
+
+// domainService returns a domain service for example.com, looked up
+// from the RDAP service at url.
+func domainService(t *testing.T, url string) *service {
+	t.Helper()
+	d := &domainCheck{Name: "example.com", RDAPURL: url}
+	if err := d.init(); err != nil {
+		t.Fatal(err)
+	}
+	return &service{Name: "example.com", Type: "domain", Domain: d}
+}
+
+func TestCheckDomain(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		name    string
+		expires time.Duration
+		status  []string
+		state   string
+		message string
+	}{
+		{"registered", 90 * day, []string{"active", "client transfer prohibited"}, stateUp, ""},
+		{"expiring", 20*day + time.Hour, nil, stateDegraded, "domain expires in 20 days"},
+		{"expiring soon", 5*day + time.Hour, nil, stateDown, "domain expires in 5 days"},
+		{"on hold", 90 * day, []string{"active", "client hold"}, stateDown, "domain status is client hold"},
+		{"pending delete", 90 * day, []string{"pending delete"}, stateDown, "domain status is pending delete"},
+	}
+	for _, tt := range tests {
+		rdap := &fakeRDAP{expires: time.Now().Add(tt.expires), status: tt.status}
+		ts := httptest.NewServer(rdap)
+		r := &result{}
+		checkDomain(domainService(t, ts.URL), &serviceState{}, r)
+		ts.Close()
+		if r.State != tt.state || !strings.HasPrefix(r.Message, tt.message) {
+			t.Errorf("%s: result = %q %q, want %q %q", tt.name, r.State, r.Message, tt.state, tt.message)
+		}
+		if r.Domain == nil || r.Domain.Registrar != "Example Registrar" {
+			t.Errorf("%s: domain = %+v, want registrar", tt.name, r.Domain)
+		}
+	}
+}
+
+func TestCheckDomainCache(t *testing.T) {
+	rdap := &fakeRDAP{expires: time.Now().Add(90 * 24 * time.Hour)}
+	ts := httptest.NewServer(rdap)
+	defer ts.Close()
+	s := domainService(t, ts.URL)
+	ss := &serviceState{}
+
+	check := func(state string, lookups int32) {
+		t.Helper()
+		r := &result{}
+		checkDomain(s, ss, r)
+		if r.State != state {
+			t.Errorf("state = %q (%s), want %q", r.State, r.Message, state)
+		}
+		if n := rdap.lookups.Load(); n != lookups {
+			t.Errorf("%d lookups, want %d", n, lookups)
+		}
+	}
+
+	// Lookups are reused for a day.
+	check(stateUp, 1)
+	check(stateUp, 1)
+	ss.Domain.Fetched = ss.Domain.Fetched.Add(-25 * time.Hour)
+	check(stateUp, 2)
+
+	// Failed refreshes fall back to the previous lookup, and are
+	// retried after an hour.
+	ss.Domain.Fetched = ss.Domain.Fetched.Add(-25 * time.Hour)
+	rdap.fail = http.StatusTooManyRequests
+	check(stateUp, 3)
+	check(stateUp, 3)
+	ss.RDAP.Failed = ss.RDAP.Failed.Add(-61 * time.Minute)
+	rdap.fail = 0
+	check(stateUp, 4)
+	check(stateUp, 4)
+
+	// Without a previous lookup, the service is down until a retry
+	// succeeds.
+	ss = &serviceState{}
+	rdap.fail = http.StatusTooManyRequests
+	check(stateDown, 5)
+	check(stateDown, 5)
+	ss.RDAP.Failed = ss.RDAP.Failed.Add(-61 * time.Minute)
+	rdap.fail = 0
+	check(stateUp, 6)
+}
+
+func TestRDAPBootstrap(t *testing.T) {
+	rdap := &fakeRDAP{expires: time.Now().Add(90 * 24 * time.Hour)}
+	rdapServer := httptest.NewServer(rdap)
+	defer rdapServer.Close()
+	var fetches atomic.Int32
+	bootstrap := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		fmt.Fprintf(w, `{"services": [[["net", "org"], ["https://other.example/"]], [["com"], [%q]]]}`, rdapServer.URL+"/")
+	}))
+	defer bootstrap.Close()
+	defer func(url string) { rdapBootstrapURL = url }(rdapBootstrapURL)
+	rdapBootstrapURL = bootstrap.URL
+
+	s := domainService(t, "")
+	ss := &serviceState{}
+	for i := 0; i < 2; i++ {
+		r := &result{}
+		checkDomain(s, ss, r)
+		if r.State != stateUp {
+			t.Fatalf("state = %q (%s), want up", r.State, r.Message)
+		}
+		ss.Domain.Fetched = time.Time{}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("bootstrap registry fetched %d times, want 1", n)
+	}
+
+	// The registry is fetched again after a lookup fails, in case
+	// the domain's RDAP service has moved.
+	rdap.fail = http.StatusNotFound
+	checkDomain(s, ss, &result{})
+	ss.RDAP.Failed = time.Time{}
+	rdap.fail = 0
+	checkDomain(s, ss, &result{})
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("bootstrap registry fetched %d times, want 2", n)
+	}
+
+	if _, err := rdapBase("example.invalid"); err == nil {
+		t.Error("rdapBase of an unknown TLD succeeded, want error")
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// fileID identifies a file independently of its path. It is always
+// zero on platforms without inodes, where rotation of log files is
+// only detected by truncation.
+type fileID struct{}
+
+func getFileID(fi os.FileInfo) fileID {
+	return fileID{}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// graphqlCheck configures a check of a GraphQL API. GraphQL servers
+// report errors in the response body, usually with a 200 status, so
+// the check fails if the response contains any errors, and may make
+// assertions on the data returned.
+type graphqlCheck struct {
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables,omitempty"`
+	OperationName string         `json:"operation_name,omitempty"`
+	// Assert holds assertions on the response's data.
+	Assert []*assertion `json:"assert,omitempty"`
+}
+
+func (g *graphqlCheck) init() error {
+	if g.Query == "" {
+		return fmt.Errorf("graphql check requires a query")
+	}
+	for _, a := range g.Assert {
+		if err := a.init(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkGraphQL posts the query to the service's URL.
+func checkGraphQL(s *service, _ *serviceState, r *result) {
+	g := s.GraphQL
+	req := map[string]any{"query": g.Query}
+	if g.Variables != nil {
+		req["variables"] = g.Variables
+	}
+	if g.OperationName != "" {
+		req["operationName"] = g.OperationName
+	}
+	var resp struct {
+		Data   any `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+			Path    []any  `json:"path"`
+		} `json:"errors"`
+	}
+	status, err := post(s, req, &resp)
+	r.Status = status
+	if err != nil {
+		r.State = stateDown
+		r.Message = err.Error()
+		return
+	}
+	if len(resp.Errors) > 0 {
+		msgs := make([]string, len(resp.Errors))
+		for i, e := range resp.Errors {
+			msgs[i] = e.Message
+			if len(e.Path) > 0 {
+				path := make([]string, len(e.Path))
+				for j, p := range e.Path {
+					path[j] = fmt.Sprint(p)
+				}
+				msgs[i] = strings.Join(path, ".") + ": " + e.Message
+			}
+		}
+		r.State = stateDown
+		r.Message = "graphql: " + strings.Join(msgs, "; ")
+		return
+	}
+	if status != http.StatusOK {
+		r.State = stateDown
+		return
+	}
+	r.State, r.Message = checkAssertions(g.Assert, resp.Data)
+}
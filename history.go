@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// historyRetention is the minimum time records are kept in the
+// check history.
+const historyRetention = 30 * 24 * time.Hour
+
+// record is an entry in the check history.
+type record struct {
+	Time    time.Time `json:"time"`
+	Service string    `json:"service"`
+	State   string    `json:"state"`
+	Latency int64     `json:"latency_ms"`
+
+	Remediation *remediation `json:"remediation,omitempty"`
+}
+
+// historyDir returns the directory of the check history in the
+// state directory dir. The history is kept in a file per UTC day,
+// each holding one JSON record per line, so that recent records can
+// be read without reading the whole history.
+func historyDir(dir string) string {
+	return filepath.Join(dir, "history")
+}
+
+// historyFile returns the path of the check history for the day of t.
+func historyFile(dir string, t time.Time) string {
+	return filepath.Join(historyDir(dir), t.UTC().Format(time.DateOnly)+".jsonl")
+}
+
+// legacyHistoryFile returns the path of the single file the check
+// history was once kept in. It is read until it is pruned.
+func legacyHistoryFile(dir string) string {
+	return filepath.Join(dir, "history.jsonl")
+}
+
+// appendHistory adds records for results to the check history.
+func appendHistory(dir string, results []*result, now time.Time) error {
+	if err := os.MkdirAll(historyDir(dir), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(historyFile(dir, now), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, r := range results {
+		err := enc.Encode(&record{
+			Time:    now,
+			Service: r.Name,
+			State:   r.State,
+			Latency: time.Duration(r.Latency).Milliseconds(),
+
+			Remediation: r.Remediation,
+		})
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readHistory returns records from the check history made at or
+// after since, keyed by service name.
+func readHistory(dir string, since time.Time) (map[string][]*record, error) {
+	records := make(map[string][]*record)
+	err := scanHistory(dir, since, func(rec *record) {
+		if !rec.Time.Before(since) {
+			records[rec.Service] = append(records[rec.Service], rec)
+		}
+	})
+	return records, err
+}
+
+// historyFiles returns the paths of the files of the check history
+// that may hold records made at or after since, oldest first, along
+// with the time after which each holds no records.
+func historyFiles(dir string, since time.Time) ([]string, []time.Time, error) {
+	var files []string
+	var ends []time.Time
+	if fi, err := os.Stat(legacyHistoryFile(dir)); err == nil {
+		if !fi.ModTime().Before(since) {
+			files = append(files, legacyHistoryFile(dir))
+			ends = append(ends, fi.ModTime())
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, err
+	}
+	entries, err := os.ReadDir(historyDir(dir))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, err
+	}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".jsonl")
+		if !ok {
+			continue
+		}
+		day, err := time.Parse(time.DateOnly, name)
+		if err != nil {
+			continue
+		}
+		if end := day.AddDate(0, 0, 1); end.After(since) {
+			files = append(files, filepath.Join(historyDir(dir), e.Name()))
+			ends = append(ends, end)
+		}
+	}
+	return files, ends, nil
+}
+
+// pruneHistory removes the files of the check history holding only
+// records made before the given time.
+func pruneHistory(dir string, before time.Time) error {
+	files, ends, err := historyFiles(dir, time.Time{})
+	if err != nil {
+		return err
+	}
+	for i, file := range files {
+		if ends[i].After(before) {
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanHistory calls f for each record in the files of the check
+// history that may hold records made at or after since. Malformed
+// lines, such as one partially written by an interrupted run, are
+// skipped.
+func scanHistory(dir string, since time.Time, f func(*record)) error {
+	files, _, err := historyFiles(dir, since)
+	if err != nil {
+		return err
+	}
+	for _, name := range files {
+		if err := scanHistoryFile(name, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanHistoryFile(name string, f func(*record)) error {
+	file, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	s := bufio.NewScanner(file)
+	for s.Scan() {
+		rec := &record{}
+		if err := json.Unmarshal(s.Bytes(), rec); err != nil {
+			continue
+		}
+		f(rec)
+	}
+	return s.Err()
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// historyFixture returns a state directory holding the legacy
+// history file, last written at legacy, and history files for 14th
+// to 16th October 2026, along with files that aren't history.
+func historyFixture(t *testing.T, legacy time.Time) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.MkdirAll(historyDir(dir), 0700); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		legacyHistoryFile(dir):                             `{"time": "2026-10-13T12:00:00Z", "service": "api", "state": "down"}`,
+		filepath.Join(historyDir(dir), "2026-10-14.jsonl"): `{"time": "2026-10-14T12:00:00Z", "service": "api", "state": "up"}`,
+		filepath.Join(historyDir(dir), "2026-10-15.jsonl"): `{"time": "2026-10-15T12:00:00Z", "service": "api", "state": "up"}` + "\n" +
+			`{"time": "2026-10-15T12:00:00Z", "service": "db", "sta`,
+		filepath.Join(historyDir(dir), "2026-10-16.jsonl"): `{"time": "2026-10-16T00:00:00Z", "service": "api", "state": "degraded"}`,
+		filepath.Join(historyDir(dir), "notes.txt"):        "",
+		filepath.Join(historyDir(dir), "latest.jsonl"):     "",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(name, []byte(data+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chtimes(legacyHistoryFile(dir), legacy, legacy); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// historyNames returns the names of history files relative to dir,
+// along with the time after which each holds no records.
+func historyNames(dir string, files []string, ends []time.Time) []string {
+	var names []string
+	for i, file := range files {
+		name, _ := filepath.Rel(dir, file)
+		names = append(names, name+" "+ends[i].UTC().Format(time.RFC3339))
+	}
+	return names
+}
+
+func TestHistoryFiles(t *testing.T) {
+	legacy := time.Date(2026, 10, 13, 12, 0, 0, 0, time.UTC)
+	dir := historyFixture(t, legacy)
+	all := []string{
+		"history.jsonl 2026-10-13T12:00:00Z",
+		"history/2026-10-14.jsonl 2026-10-15T00:00:00Z",
+		"history/2026-10-15.jsonl 2026-10-16T00:00:00Z",
+		"history/2026-10-16.jsonl 2026-10-17T00:00:00Z",
+	}
+	tests := []struct {
+		since time.Time
+		want  []string
+	}{
+		{time.Time{}, all},
+		// The legacy file holds records until it was last written.
+		{legacy, all},
+		{legacy.Add(time.Second), all[1:]},
+		// Daily files hold records until the end of their day.
+		{time.Date(2026, 10, 14, 23, 59, 59, 0, time.UTC), all[1:]},
+		{time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC), all[2:]},
+		{time.Date(2026, 10, 16, 23, 59, 59, 0, time.UTC), all[3:]},
+		{time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC), nil},
+	}
+	for _, tt := range tests {
+		files, ends, err := historyFiles(dir, tt.since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := historyNames(dir, files, ends); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("files since %s:\n%s\nwant:\n%s", tt.since,
+				strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+
+	// A state directory without history has no history files.
+	files, _, err := historyFiles(t.TempDir(), time.Time{})
+	if err != nil || len(files) != 0 {
+		t.Errorf("empty history: files %q, error %v", files, err)
+	}
+}
+
+func TestPruneHistory(t *testing.T) {
+	legacy := time.Date(2026, 10, 13, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		before time.Time
+		want   []string
+	}{
+		{legacy.Add(-time.Second), []string{"history.jsonl", "2026-10-14.jsonl", "2026-10-15.jsonl", "2026-10-16.jsonl"}},
+		{legacy, []string{"2026-10-14.jsonl", "2026-10-15.jsonl", "2026-10-16.jsonl"}},
+		// A day's file is kept until every record it may hold has
+		// expired.
+		{time.Date(2026, 10, 14, 23, 59, 59, 0, time.UTC), []string{"2026-10-14.jsonl", "2026-10-15.jsonl", "2026-10-16.jsonl"}},
+		{time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC), []string{"2026-10-15.jsonl", "2026-10-16.jsonl"}},
+		{time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC), nil},
+	}
+	for _, tt := range tests {
+		dir := historyFixture(t, legacy)
+		if err := pruneHistory(dir, tt.before); err != nil {
+			t.Fatal(err)
+		}
+		files, _, err := historyFiles(dir, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, file := range files {
+			got = append(got, filepath.Base(file))
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("pruned before %s: kept %q, want %q", tt.before, got, tt.want)
+		}
+		// Files that aren't history are left alone.
+		if _, err := os.Stat(filepath.Join(historyDir(dir), "notes.txt")); err != nil {
+			t.Errorf("pruned before %s: %v", tt.before, err)
+		}
+	}
+}
+
+func TestReadHistory(t *testing.T) {
+	dir := historyFixture(t, time.Date(2026, 10, 13, 12, 0, 0, 0, time.UTC))
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	results := []*result{
+		{Name: "api", State: stateUp, Latency: duration(1500 * time.Microsecond)},
+		{Name: "db", State: stateDown},
+	}
+	if err := appendHistory(dir, results, now); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		since time.Time
+		want  string
+	}{
+		// The malformed record is skipped.
+		{time.Time{}, "api 2026-10-13T12:00:00Z down, api 2026-10-14T12:00:00Z up, " +
+			"api 2026-10-15T12:00:00Z up, api 2026-10-16T00:00:00Z degraded, api 2026-10-16T12:00:00Z up 1ms; " +
+			"db 2026-10-16T12:00:00Z down"},
+		// Records made at since are read, those before aren't.
+		{time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC), "api 2026-10-15T12:00:00Z up, " +
+			"api 2026-10-16T00:00:00Z degraded, api 2026-10-16T12:00:00Z up 1ms; db 2026-10-16T12:00:00Z down"},
+		{time.Date(2026, 10, 15, 12, 0, 1, 0, time.UTC), "api 2026-10-16T00:00:00Z degraded, " +
+			"api 2026-10-16T12:00:00Z up 1ms; db 2026-10-16T12:00:00Z down"},
+		{now.Add(time.Second), ""},
+	}
+	for _, tt := range tests {
+		records, err := readHistory(dir, tt.since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var services []string
+		for _, name := range []string{"api", "db"} {
+			var recs []string
+			for _, rec := range records[name] {
+				s := rec.Service + " " + rec.Time.UTC().Format(time.RFC3339) + " " + rec.State
+				if rec.Latency != 0 {
+					s += " " + (time.Duration(rec.Latency) * time.Millisecond).String()
+				}
+				recs = append(recs, s)
+			}
+			if len(recs) > 0 {
+				services = append(services, strings.Join(recs, ", "))
+			}
+		}
+		if got := strings.Join(services, "; "); got != tt.want {
+			t.Errorf("history since %s:\n%s\nwant:\n%s", tt.since, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// hostCheck configures a check of the resources of the host mon is
+// running on. Each metric is a percentage used, except load, which
+// is the five minute load average per CPU.
+type hostCheck struct {
+	Disk   *mountThreshold `json:"disk,omitempty"`
+	Inodes *mountThreshold `json:"inodes,omitempty"`
+	Memory *threshold      `json:"memory,omitempty"`
+	Swap   *threshold      `json:"swap,omitempty"`
+	Load   *threshold      `json:"load,omitempty"`
+	FDs    *threshold      `json:"fds,omitempty"`
+}
+
+// threshold holds the levels at which a metric is degraded (Warning)
+// or down (Critical). Zero levels are not checked.
+type threshold struct {
+	Warning  float64 `json:"warning,omitempty"`
+	Critical float64 `json:"critical,omitempty"`
+}
+
+// mountThreshold is a threshold applied to each of Mounts, or to
+// every local filesystem that can be read if Mounts is empty.
+type mountThreshold struct {
+	threshold
+	Mounts []string `json:"mounts,omitempty"`
+}
+
+// state returns the state of a metric with value v.
+func (t *threshold) state(v float64) string {
+	switch {
+	case t.Critical > 0 && v >= t.Critical:
+		return stateDown
+	case t.Warning > 0 && v >= t.Warning:
+		return stateDegraded
+	}
+	return stateUp
+}
+
+// metric is a measured value. It is used for output.
+type metric struct {
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit,omitempty"`
+	State string  `json:"state"`
+}
+
+// latencyMetric returns a metric for an operation taking d, which is
+// degraded if d exceeds max.
+func latencyMetric(name string, d time.Duration, max *duration) *metric {
+	m := &metric{Name: name, Value: d.Seconds(), Unit: "s", State: stateUp}
+	if max != nil && d > time.Duration(*max) {
+		m.State = stateDegraded
+	}
+	return m
+}
+
+// worse returns the worse of two states.
+func worse(a, b string) string {
+	rank := map[string]int{stateUp: 0, stateDegraded: 1, stateDown: 2}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
+
+// checkHost reads the host's resource usage, judging each metric
+// against its thresholds. The service takes the state of its worst
+// metric.
+func checkHost(s *service, _ *serviceState, r *result) {
+	metrics, err := readHostMetrics(s.Host)
+	if err != nil {
+		r.State = stateDown
+		r.Message = err.Error()
+		return
+	}
+	r.State = stateUp
+	var breaches []string
+	for _, m := range metrics {
+		r.State = worse(r.State, m.State)
+		if m.State != stateUp {
+			breaches = append(breaches, fmt.Sprintf("%s %.1f%s", m.Name, m.Value, m.Unit))
+		}
+	}
+	r.Metrics = metrics
+	r.Message = strings.Join(breaches, "; ")
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// pseudoFilesystems are filesystem types not checked when a disk or
+// inode threshold applies to every mounted filesystem.
+var pseudoFilesystems = map[string]bool{
+	"autofs": true, "binfmt_misc": true, "bpf": true, "cgroup": true,
+	"cgroup2": true, "configfs": true, "debugfs": true, "devpts": true,
+	"devtmpfs": true, "efivarfs": true, "fusectl": true, "hugetlbfs": true,
+	"mqueue": true, "nsfs": true, "proc": true, "pstore": true,
+	"ramfs": true, "rpc_pipefs": true, "securityfs": true, "selinuxfs": true,
+	"squashfs": true, "sysfs": true, "tmpfs": true, "tracefs": true,
+}
+
+// networkFilesystems are filesystem types not checked when a disk or
+// inode threshold applies to every mounted filesystem, as statfs(2)
+// blocks while their server is unreachable. They are checked if
+// listed in the threshold's mounts.
+var networkFilesystems = map[string]bool{
+	"9p": true, "afs": true, "ceph": true, "cifs": true,
+	"fuse.gcsfuse": true, "fuse.glusterfs": true, "fuse.rclone": true,
+	"fuse.s3fs": true, "fuse.sshfs": true, "glusterfs": true,
+	"lustre": true, "ncpfs": true, "nfs": true, "nfs4": true,
+	"smb3": true, "smbfs": true,
+}
+
+// readHostMetrics reads the metrics configured in h from /proc and
+// statfs(2).
+func readHostMetrics(h *hostCheck) ([]*metric, error) {
+	var metrics []*metric
+	if h.Disk != nil || h.Inodes != nil {
+		mounts, err := readMounts()
+		if err != nil {
+			return nil, err
+		}
+		for _, mt := range []struct {
+			name string
+			t    *mountThreshold
+		}{{"disk", h.Disk}, {"inodes", h.Inodes}} {
+			if mt.t == nil {
+				continue
+			}
+			paths := mt.t.Mounts
+			if len(paths) == 0 {
+				paths = mounts
+			}
+			for _, p := range paths {
+				var fs syscall.Statfs_t
+				if err := syscall.Statfs(p, &fs); err != nil {
+					// Of every mounted filesystem, skip those
+					// that can't be read, such as other users'
+					// FUSE mounts or ones unmounted since being
+					// listed.
+					if len(mt.t.Mounts) == 0 {
+						continue
+					}
+					return nil, fmt.Errorf("statfs %s: %w", p, err)
+				}
+				var used float64
+				if mt.name == "disk" {
+					// As df(1), excluding blocks reserved for root.
+					total := fs.Blocks - fs.Bfree + fs.Bavail
+					if total == 0 {
+						continue
+					}
+					used = 100 * float64(fs.Blocks-fs.Bfree) / float64(total)
+				} else {
+					if fs.Files == 0 {
+						continue
+					}
+					used = 100 * float64(fs.Files-fs.Ffree) / float64(fs.Files)
+				}
+				metrics = append(metrics, &metric{
+					Name:  mt.name + " " + p,
+					Value: used,
+					Unit:  "%",
+					State: mt.t.state(used),
+				})
+			}
+		}
+	}
+
+	if h.Memory != nil || h.Swap != nil {
+		mem, err := readMeminfo()
+		if err != nil {
+			return nil, err
+		}
+		if h.Memory != nil && mem["MemTotal"] > 0 {
+			used := 100 * (1 - mem["MemAvailable"]/mem["MemTotal"])
+			metrics = append(metrics, &metric{
+				Name:  "memory",
+				Value: used,
+				Unit:  "%",
+				State: h.Memory.state(used),
+			})
+		}
+		if h.Swap != nil && mem["SwapTotal"] > 0 {
+			used := 100 * (1 - mem["SwapFree"]/mem["SwapTotal"])
+			metrics = append(metrics, &metric{
+				Name:  "swap",
+				Value: used,
+				Unit:  "%",
+				State: h.Swap.state(used),
+			})
+		}
+	}
+
+	if h.Load != nil {
+		fields, err := readFields("/proc/loadavg")
+		if err != nil {
+			return nil, err
+		}
+		load, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing /proc/loadavg: %w", err)
+		}
+		load /= float64(runtime.NumCPU())
+		metrics = append(metrics, &metric{
+			Name:  "load",
+			Value: load,
+			State: h.Load.state(load),
+		})
+	}
+
+	if h.FDs != nil {
+		// file-nr holds the number of allocated file handles, the
+		// number allocated but unused, and the maximum.
+		fields, err := readFields("/proc/sys/fs/file-nr")
+		if err != nil {
+			return nil, err
+		}
+		var n [3]float64
+		for i := range n {
+			if n[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+				return nil, fmt.Errorf("parsing /proc/sys/fs/file-nr: %w", err)
+			}
+		}
+		used := 100 * (n[0] - n[1]) / n[2]
+		metrics = append(metrics, &metric{
+			Name:  "fds",
+			Value: used,
+			Unit:  "%",
+			State: h.FDs.state(used),
+		})
+	}
+	return metrics, nil
+}
+
+// readFields returns the whitespace separated fields of a file with
+// at least three fields.
+func readFields(file string) ([]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("unexpected contents of %s", file)
+	}
+	return fields, nil
+}
+
+// readMeminfo returns the values in /proc/meminfo, in kB.
+func readMeminfo() (map[string]float64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	mem := make(map[string]float64)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		k, v, ok := strings.Cut(s.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(v)
+		if len(fields) == 0 {
+			continue
+		}
+		if n, err := strconv.ParseFloat(fields[0], 64); err == nil {
+			mem[k] = n
+		}
+	}
+	return mem, s.Err()
+}
+
+// readMounts returns the mount points of real, local filesystems.
+func readMounts() ([]string, error) {
+	f, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var mounts []string
+	seen := make(map[string]bool)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 3 || pseudoFilesystems[fields[2]] || networkFilesystems[fields[2]] {
+			continue
+		}
+		p := unescapeMount(fields[1])
+		if !seen[p] {
+			seen[p] = true
+			mounts = append(mounts, p)
+		}
+	}
+	return mounts, s.Err()
+}
+
+// unescapeMount decodes the octal escapes used for whitespace and
+// backslashes in /proc/self/mounts.
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// readHostMetrics is only supported on Linux.
+func readHostMetrics(h *hostCheck) ([]*metric, error) {
+	return nil, errors.New("host checks are only supported on Linux")
+}
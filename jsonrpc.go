@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// jsonrpcCheck configures a check of a JSON-RPC 2.0 API. JSON-RPC
+// servers report errors in the response body, usually with a 200
+// status, so the check fails if the response contains an error, and
+// may make assertions on the result.
+type jsonrpcCheck struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+	// Assert holds assertions on the response's result.
+	Assert []*assertion `json:"assert,omitempty"`
+}
+
+func (j *jsonrpcCheck) init() error {
+	if j.Method == "" {
+		return fmt.Errorf("jsonrpc check requires a method")
+	}
+	for _, a := range j.Assert {
+		if err := a.init(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkJSONRPC calls the method at the service's URL.
+func checkJSONRPC(s *service, _ *serviceState, r *result) {
+	j := s.JSONRPC
+	req := map[string]any{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  j.Method,
+	}
+	if j.Params != nil {
+		req["params"] = j.Params
+	}
+	var resp struct {
+		Result any `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	status, err := post(s, req, &resp)
+	r.Status = status
+	if err != nil {
+		r.State = stateDown
+		r.Message = err.Error()
+		return
+	}
+	if resp.Error != nil {
+		r.State = stateDown
+		r.Message = fmt.Sprintf("jsonrpc error %d: %s", resp.Error.Code, resp.Error.Message)
+		return
+	}
+	if status != http.StatusOK {
+		r.State = stateDown
+		return
+	}
+	r.State, r.Message = checkAssertions(j.Assert, resp.Result)
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// ldapCheck configures a check of an LDAP directory. The check binds
+// to the directory and searches it, so it fails when a directory
+// accepts connections but cannot authenticate or serve entries.
+type ldapCheck struct {
+	// Address is the directory's host and port. The port defaults
+	// to 636 with implicit TLS, and 389 otherwise.
+	Address string `json:"address"`
+	// TLS is one of "tls", for implicit TLS (LDAPS), "starttls" or
+	// "none".
+	TLS string `json:"tls,omitempty"`
+	// InsecureSkipVerify disables verification of the server's
+	// certificate.
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+
+	// BindDN and Password are the credentials for a simple bind.
+	// Without a BindDN, the bind is anonymous.
+	BindDN   string `json:"bind_dn,omitempty"`
+	Password secret `json:"password,omitempty"`
+
+	BaseDN string `json:"base_dn"`
+	// Scope is one of "base", "one" or "sub" (the default).
+	Scope string `json:"scope,omitempty"`
+	// Filter is an RFC 4515 search filter, "(objectClass=*)" by
+	// default. Extensible matches are not supported.
+	Filter string `json:"filter,omitempty"`
+	// MinEntries is the number of entries the search must return
+	// for the service to be up (default 1).
+	MinEntries int `json:"min_entries,omitempty"`
+
+	// Timeout limits the whole check (default 10s).
+	Timeout *duration `json:"timeout,omitempty"`
+	// MaxLatency is the bind or search time above which the service
+	// is degraded.
+	MaxLatency *duration `json:"max_latency,omitempty"`
+
+	filter []byte
+}
+
+// ldapScopes maps search scopes to their LDAP enumeration values.
+var ldapScopes = map[string]int{"base": 0, "one": 1, "sub": 2}
+
+func (c *ldapCheck) init() error {
+	if c.Address == "" {
+		return fmt.Errorf("ldap check requires an address")
+	}
+	switch c.TLS {
+	case "tls", "starttls", "none":
+	case "":
+		c.TLS = "starttls"
+	default:
+		return fmt.Errorf("unknown tls mode %q", c.TLS)
+	}
+	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+		port := "389"
+		if c.TLS == "tls" {
+			port = "636"
+		}
+		c.Address = net.JoinHostPort(c.Address, port)
+	}
+	if c.Scope == "" {
+		c.Scope = "sub"
+	}
+	if _, ok := ldapScopes[c.Scope]; !ok {
+		return fmt.Errorf("unknown search scope %q", c.Scope)
+	}
+	if c.Filter == "" {
+		c.Filter = "(objectClass=*)"
+	}
+	var err error
+	if c.filter, err = parseLDAPFilter(c.Filter); err != nil {
+		return fmt.Errorf("filter %q: %w", c.Filter, err)
+	}
+	if c.MinEntries == 0 {
+		c.MinEntries = 1
+	}
+	if c.Timeout == nil {
+		d := duration(10 * time.Second)
+		c.Timeout = &d
+	}
+	return nil
+}
+
+// checkLDAP binds to the directory and runs the configured search,
+// reporting the time taken by each as the "bind" and "search"
+// metrics.
+func checkLDAP(s *service, _ *serviceState, r *result) {
+	c := s.LDAP
+	r.URL = "ldap://" + c.Address
+	if c.TLS == "tls" {
+		r.URL = "ldaps://" + c.Address
+	}
+	conn, err := c.dial()
+	if err != nil {
+		r.State = stateDown
+		r.Message = err.Error()
+		return
+	}
+	defer conn.close()
+
+	start := time.Now()
+	if err := conn.bind(c); err != nil {
+		r.State = stateDown
+		r.Message = fmt.Sprintf("bind: %v", err)
+		return
+	}
+	bind := time.Since(start)
+	r.Metrics = append(r.Metrics, latencyMetric("bind", bind, c.MaxLatency))
+
+	start = time.Now()
+	entries, err := conn.search(c)
+	if err != nil {
+		r.State = stateDown
+		r.Message = fmt.Sprintf("search: %v", err)
+		return
+	}
+	r.Metrics = append(r.Metrics, latencyMetric("search", time.Since(start), c.MaxLatency))
+
+	r.State = stateUp
+	for _, m := range r.Metrics {
+		r.State = worse(r.State, m.State)
+	}
+	if r.State != stateUp {
+		r.Message = "slow response"
+	}
+	if entries < c.MinEntries {
+		r.State = stateDown
+		r.Message = fmt.Sprintf("search returned %d entries, want at least %d", entries, c.MinEntries)
+	}
+}
+
+// LDAP protocol operation tags.
+const (
+	ldapBindRequest       = 0x60
+	ldapBindResponse      = 0x61
+	ldapUnbindRequest     = 0x42
+	ldapSearchRequest     = 0x63
+	ldapSearchEntry       = 0x64
+	ldapSearchDone        = 0x65
+	ldapSearchReference   = 0x73
+	ldapExtendedRequest   = 0x77
+	ldapExtendedResponse  = 0x78
+	ldapStartTLSOID       = "1.3.6.1.4.1.1466.20037"
+	ldapSizeLimitExceeded = 4
+)
+
+// ldapConn is a minimal LDAPv3 client connection, supporting only
+// StartTLS, simple binds and searches.
+type ldapConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	id   int
+}
+
+// dial connects to the directory, negotiating TLS if configured.
+func (c *ldapCheck) dial() (*ldapConn, error) {
+	host, _, _ := net.SplitHostPort(c.Address)
+	config := &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	dialer := &net.Dialer{Timeout: time.Duration(*c.Timeout)}
+	var conn net.Conn
+	var err error
+	if c.TLS == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.Address, config)
+	} else {
+		conn, err = dialer.Dial("tcp", c.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(time.Duration(*c.Timeout)))
+	lc := &ldapConn{conn: conn, r: bufio.NewReader(conn)}
+	if c.TLS == "starttls" {
+		op := ber(ldapExtendedRequest, berString(0x80, ldapStartTLSOID))
+		if _, err := lc.request(op, ldapExtendedResponse); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("starttls: %w", err)
+		}
+		tc := tls.Client(conn, config)
+		if err := tc.Handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("starttls: %w", err)
+		}
+		lc.conn = tc
+		lc.r = bufio.NewReader(tc)
+	}
+	return lc, nil
+}
+
+// bind makes a simple bind with the configured credentials.
+func (lc *ldapConn) bind(c *ldapCheck) error {
+	password, err := c.Password.value()
+	if err != nil {
+		return err
+	}
+	op := ber(ldapBindRequest,
+		berInt(berInteger, 3),
+		berString(berOctetString, c.BindDN),
+		berString(0x80, password))
+	_, err = lc.request(op, ldapBindResponse)
+	return err
+}
+
+// search runs the configured search, returning the number of entries
+// found. No attributes are requested, and the search is limited to
+// the minimum number of entries required.
+func (lc *ldapConn) search(c *ldapCheck) (int, error) {
+	op := ber(ldapSearchRequest,
+		berString(berOctetString, c.BaseDN),
+		berInt(berEnumerated, ldapScopes[c.Scope]),
+		berInt(berEnumerated, 0),
+		berInt(berInteger, c.MinEntries),
+		berInt(berInteger, int(time.Duration(*c.Timeout).Seconds())),
+		berBool(true),
+		c.filter,
+		ber(berSequence, berString(berOctetString, "1.1")))
+	if err := lc.send(op); err != nil {
+		return 0, err
+	}
+	entries := 0
+	for {
+		tag, content, err := lc.receive()
+		if err != nil {
+			return entries, err
+		}
+		switch tag {
+		case ldapSearchEntry:
+			entries++
+		case ldapSearchReference:
+		case ldapSearchDone:
+			code, err := ldapResult(content)
+			if code == ldapSizeLimitExceeded {
+				err = nil
+			}
+			return entries, err
+		default:
+			return entries, fmt.Errorf("unexpected response 0x%02x", tag)
+		}
+	}
+}
+
+func (lc *ldapConn) close() {
+	lc.send(ber(ldapUnbindRequest))
+	lc.conn.Close()
+}
+
+// send sends an LDAP message with the next message ID containing the
+// protocol operation op.
+func (lc *ldapConn) send(op []byte) error {
+	lc.id++
+	_, err := lc.conn.Write(ber(berSequence, berInt(berInteger, lc.id), op))
+	return err
+}
+
+// receive reads the next message for the current message ID,
+// returning its protocol operation's tag and content.
+func (lc *ldapConn) receive() (byte, []byte, error) {
+	for {
+		msg, err := readBER(lc.r)
+		if err != nil {
+			return 0, nil, err
+		}
+		tag, content, _, err := berNext(msg)
+		if err != nil {
+			return 0, nil, err
+		}
+		if tag != berSequence {
+			return 0, nil, fmt.Errorf("unexpected message 0x%02x", tag)
+		}
+		_, id, content, err := berNext(content)
+		if err != nil {
+			return 0, nil, err
+		}
+		op, content, _, err := berNext(content)
+		if err != nil {
+			return 0, nil, err
+		}
+		// Unsolicited notifications, such as notice of
+		// disconnection, have message ID 0.
+		if berReadInt(id) == 0 {
+			if _, err := ldapResult(content); err != nil {
+				return 0, nil, err
+			}
+			return 0, nil, fmt.Errorf("unsolicited notification")
+		}
+		if berReadInt(id) == lc.id {
+			return op, content, nil
+		}
+	}
+}
+
+// request sends the operation op, and reads its response, which must
+// have the tag want. It returns the response's content.
+func (lc *ldapConn) request(op []byte, want byte) ([]byte, error) {
+	if err := lc.send(op); err != nil {
+		return nil, err
+	}
+	tag, content, err := lc.receive()
+	if err != nil {
+		return nil, err
+	}
+	if tag != want {
+		return nil, fmt.Errorf("unexpected response 0x%02x", tag)
+	}
+	_, err = ldapResult(content)
+	return content, err
+}
+
+// ldapResultCodes names common LDAP result codes.
+var ldapResultCodes = map[int]string{
+	1:  "operations error",
+	2:  "protocol error",
+	3:  "time limit exceeded",
+	4:  "size limit exceeded",
+	7:  "auth method not supported",
+	8:  "stronger auth required",
+	13: "confidentiality required",
+	32: "no such object",
+	34: "invalid DN syntax",
+	48: "inappropriate authentication",
+	49: "invalid credentials",
+	50: "insufficient access rights",
+	51: "busy",
+	52: "unavailable",
+	53: "unwilling to perform",
+	80: "other",
+}
+
+// ldapResult decodes the LDAPResult at the start of a response's
+// content, returning its result code, and an error if the code is
+// not success.
+func ldapResult(b []byte) (int, error) {
+	_, code, b, err := berNext(b)
+	if err != nil {
+		return 0, err
+	}
+	_, _, b, err = berNext(b)
+	if err != nil {
+		return 0, err
+	}
+	_, msg, _, err := berNext(b)
+	if err != nil {
+		return 0, err
+	}
+	n := berReadInt(code)
+	if n == 0 {
+		return 0, nil
+	}
+	text := ldapResultCodes[n]
+	if text == "" {
+		text = fmt.Sprintf("result code %d", n)
+	}
+	if len(msg) > 0 {
+		text += ": " + string(msg)
+	}
+	return n, fmt.Errorf("%s", text)
+}
+
+// parseLDAPFilter encodes an RFC 4515 string search filter.
+func parseLDAPFilter(s string) ([]byte, error) {
+	b, rest, err := ldapFilter(s)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("unexpected %q after filter", rest)
+	}
+	return b, nil
+}
+
+// ldapFilter encodes the filter at the start of s, returning the
+// remainder of s.
+func ldapFilter(s string) ([]byte, string, error) {
+	if len(s) < 2 || s[0] != '(' {
+		return nil, "", fmt.Errorf("expected ( at %q", s)
+	}
+	switch s[1] {
+	case '&', '|', '!':
+		tag := map[byte]byte{'&': 0xa0, '|': 0xa1, '!': 0xa2}[s[1]]
+		var filters [][]byte
+		rest := s[2:]
+		for strings.HasPrefix(rest, "(") {
+			f, r, err := ldapFilter(rest)
+			if err != nil {
+				return nil, "", err
+			}
+			filters = append(filters, f)
+			rest = r
+		}
+		if !strings.HasPrefix(rest, ")") {
+			return nil, "", fmt.Errorf("expected ) at %q", rest)
+		}
+		if s[1] == '!' && len(filters) != 1 {
+			return nil, "", fmt.Errorf("! requires a single filter")
+		}
+		return ber(tag, filters...), rest[1:], nil
+	}
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", fmt.Errorf("missing ) in %q", s)
+	}
+	f, err := ldapItem(s[1:end])
+	return f, s[end+1:], err
+}
+
+// ldapItem encodes a simple filter item such as "cn=admin".
+func ldapItem(s string) ([]byte, error) {
+	i := strings.IndexByte(s, '=')
+	if i < 1 {
+		return nil, fmt.Errorf("invalid filter item %q", s)
+	}
+	attr, value := s[:i], s[i+1:]
+	var tag byte = 0xa3
+	switch attr[len(attr)-1] {
+	case '~':
+		tag = 0xa8
+	case '>':
+		tag = 0xa5
+	case '<':
+		tag = 0xa6
+	case ':':
+		return nil, fmt.Errorf("extensible match %q not supported", s)
+	}
+	if tag != 0xa3 {
+		attr = attr[:len(attr)-1]
+	}
+	if attr == "" || strings.ContainsAny(attr, "()*\\ ") {
+		return nil, fmt.Errorf("invalid attribute in %q", s)
+	}
+	if tag == 0xa3 && value == "*" {
+		return berString(0x87, attr), nil
+	}
+	if tag == 0xa3 && strings.Contains(value, "*") {
+		parts := strings.Split(value, "*")
+		var subs [][]byte
+		for i, p := range parts {
+			if p == "" {
+				continue
+			}
+			v, err := ldapUnescape(p)
+			if err != nil {
+				return nil, err
+			}
+			var t byte = 0x81
+			switch i {
+			case 0:
+				t = 0x80
+			case len(parts) - 1:
+				t = 0x82
+			}
+			subs = append(subs, berString(t, v))
+		}
+		return ber(0xa4, berString(berOctetString, attr), ber(berSequence, subs...)), nil
+	}
+	v, err := ldapUnescape(value)
+	if err != nil {
+		return nil, err
+	}
+	return ber(tag, berString(berOctetString, attr), berString(berOctetString, v)), nil
+}
+
+// ldapUnescape decodes the \XX escapes in a filter value.
+func ldapUnescape(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return "", fmt.Errorf("invalid escape in %q", s)
+		}
+		c, err := hex.DecodeString(s[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in %q", s)
+		}
+		b.Write(c)
+		i += 2
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// unhex decodes a hex string, ignoring spaces.
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestBER(t *testing.T) {
+	tests := []struct {
+		name string
+		got  []byte
+		want string
+	}{
+		{"string", berString(berOctetString, "cn"), "04 02 636e"},
+		{"empty", ber(berSequence), "30 00"},
+		{"nested", ber(berSequence, berInt(berInteger, 1), berBool(true)), "30 06 020101 0101ff"},
+		{"int 0", berInt(berInteger, 0), "02 01 00"},
+		{"int 127", berInt(berInteger, 127), "02 01 7f"},
+		{"int 128", berInt(berInteger, 128), "02 02 0080"},
+		{"int 256", berInt(berInteger, 256), "02 02 0100"},
+		{"enumerated", berInt(berEnumerated, 3), "0a 01 03"},
+		{"false", berBool(false), "01 01 00"},
+		{"long length", ber(berOctetString, make([]byte, 200))[:3], "04 81 c8"},
+		{"longer length", ber(berOctetString, make([]byte, 300))[:4], "04 82 012c"},
+	}
+	for _, tt := range tests {
+		if want := unhex(t, tt.want); !bytes.Equal(tt.got, want) {
+			t.Errorf("%s: got % x, want % x", tt.name, tt.got, want)
+		}
+	}
+}
+
+func TestBERNext(t *testing.T) {
+	long := bytes.Repeat([]byte("x"), 300)
+	b := append(ber(berOctetString, long), berInt(berEnumerated, 5)...)
+
+	tag, content, rest, err := berNext(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag != berOctetString || !bytes.Equal(content, long) {
+		t.Errorf("first element = %x %d bytes, want octet string of %d bytes", tag, len(content), len(long))
+	}
+	tag, content, rest, err = berNext(rest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag != berEnumerated || berReadInt(content) != 5 || len(rest) != 0 {
+		t.Errorf("second element = %x %d, rest %d bytes, want enumerated 5", tag, berReadInt(content), len(rest))
+	}
+
+	for _, bad := range []string{"", "04", "04 05 6162", "04 80", "04 85 0000000001", "04 82 01"} {
+		if _, _, _, err := berNext(unhex(t, bad)); err == nil {
+			t.Errorf("berNext(%s) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestBERReadInt(t *testing.T) {
+	for s, want := range map[string]int{
+		"":     0,
+		"00":   0,
+		"7f":   127,
+		"0080": 128,
+		"0100": 256,
+		"ff":   -1,
+		"80":   -128,
+		"ff7f": -129,
+	} {
+		if got := berReadInt(unhex(t, s)); got != want {
+			t.Errorf("berReadInt(%s) = %d, want %d", s, got, want)
+		}
+	}
+}
+
+func TestReadBER(t *testing.T) {
+	msg := ber(berSequence, berInt(berInteger, 1), berString(berOctetString, strings.Repeat("x", 1000)))
+	r := bufio.NewReader(bytes.NewReader(append(msg, msg...)))
+	for i := 0; i < 2; i++ {
+		got, err := readBER(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Errorf("message %d: read %d bytes, want %d", i, len(got), len(msg))
+		}
+	}
+
+	for _, bad := range []string{
+		"30",
+		"30 05 0201",
+		// Longer than maxBERLength.
+		"30 84 7fffffff",
+	} {
+		if _, err := readBER(bufio.NewReader(bytes.NewReader(unhex(t, bad)))); err == nil {
+			t.Errorf("readBER(%s) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestParseLDAPFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   string
+	}{
+		// equalityMatch [3] { "cn", "admin" }
+		{"(cn=admin)", "a3 0b 0402636e 0405 61646d696e"},
+		// present [7] "objectClass"
+		{"(objectClass=*)", "87 0b 6f626a656374436c617373"},
+		// greaterOrEqual [5], lessOrEqual [6] and approxMatch [8]
+		{"(uid>=5)", "a5 08 0403756964 040135"},
+		{"(uid<=5)", "a6 08 0403756964 040135"},
+		{"(cn~=bob)", "a8 09 0402636e 0403626f62"},
+		// substrings [4] { "cn", { initial "ad", any "m", final "n" } }
+		{"(cn=ad*m*n)", "a4 10 0402636e 300a 80026164 81016d 82016e"},
+		{"(cn=*m*)", "a4 09 0402636e 3003 81016d"},
+		{"(cn=ad*)", "a4 0a 0402636e 3004 80026164"},
+		// Escaped values.
+		{`(cn=a\2ab)`, "a3 09 0402636e 0403 612a62"},
+		{`(cn=\28x\29)`, "a3 09 0402636e 0403 287829"},
+		// and [0] { (a=1), not [2] (b=2) }
+		{"(&(a=1)(!(b=2)))", "a0 12 a306040161040131 a208 a306040162040132"},
+		// or [1] { (a=1), (b=2) }
+		{"(|(a=1)(b=2))", "a1 10 a306040161040131 a306040162040132"},
+	}
+	for _, tt := range tests {
+		got, err := parseLDAPFilter(tt.filter)
+		if err != nil {
+			t.Errorf("parseLDAPFilter(%q): %v", tt.filter, err)
+			continue
+		}
+		want := unhex(t, tt.want)
+		if !bytes.Equal(got, want) {
+			t.Errorf("parseLDAPFilter(%q) = % x, want % x", tt.filter, got, want)
+		}
+	}
+
+	for _, bad := range []string{
+		"",
+		"cn=admin",
+		"(cn=admin",
+		"(cn=admin))",
+		"(=admin)",
+		"(cn:=admin)",
+		"(c n=admin)",
+		`(cn=\zz)`,
+		`(cn=\2)`,
+		"(!(a=1)(b=2))",
+		"(&(a=1)",
+	} {
+		if _, err := parseLDAPFilter(bad); err == nil {
+			t.Errorf("parseLDAPFilter(%q) succeeded, want error", bad)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// logfileCheck configures a check of the lines appended to a log file
+// since the previous check.
+type logfileCheck struct {
+	Path string `json:"path"`
+	// Patterns are regular expressions; lines matching any of them
+	// are counted.
+	Patterns []string `json:"patterns"`
+	// Window is the period over which matches are counted.
+	Window *duration `json:"window,omitempty"`
+	// Thresholds are the numbers of matches within the window at
+	// which the service is degraded or down. The warning threshold
+	// defaults to 1, so any match degrades the service.
+	threshold
+	// MaxLines is the number of the most recent matching lines
+	// reported.
+	MaxLines int `json:"max_lines,omitempty"`
+
+	res []*regexp.Regexp
+}
+
+func (l *logfileCheck) init() error {
+	if l.Path == "" || len(l.Patterns) == 0 {
+		return fmt.Errorf("logfile check requires a path and patterns")
+	}
+	for _, p := range l.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return err
+		}
+		l.res = append(l.res, re)
+	}
+	if l.Window == nil {
+		w := duration(10 * time.Minute)
+		l.Window = &w
+	}
+	if l.Warning == 0 {
+		l.Warning = 1
+	}
+	if l.MaxLines == 0 {
+		l.MaxLines = 5
+	}
+	return nil
+}
+
+// logState is the persisted position in a log file, and the recent
+// matches found in it.
+type logState struct {
+	File    fileID      `json:"file"`
+	Offset  int64       `json:"offset"`
+	Matches []*logCount `json:"matches,omitempty"`
+	Lines   []string    `json:"lines,omitempty"`
+}
+
+// logCount is the number of matching lines found by a check.
+type logCount struct {
+	Time  time.Time `json:"time"`
+	Count int       `json:"count"`
+}
+
+// checkLogfile reads lines appended to the log file since the
+// previous check, counting those matching the check's patterns.
+// Reading starts from the end of the file the first time it is
+// checked. A file replaced by rotation is read from its start, after
+// reading the remainder of the rotated file if it is found at the
+// same path with a ".1" suffix; a truncated file is also read from
+// its start.
+func checkLogfile(s *service, ss *serviceState, r *result) {
+	l := s.Logfile
+	now := time.Now()
+	f, err := os.Open(l.Path)
+	if err != nil {
+		r.State = stateDown
+		r.Message = err.Error()
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		r.State = stateDown
+		r.Message = err.Error()
+		return
+	}
+	id := getFileID(fi)
+
+	matches, lines := 0, []string(nil)
+	scan := func(f *os.File, offset int64) (int64, error) {
+		n, found, err := l.scan(f, offset)
+		matches += len(found)
+		lines = append(lines, found...)
+		return n, err
+	}
+
+	ls := ss.Log
+	switch {
+	case ls == nil:
+		ls = &logState{File: id, Offset: fi.Size()}
+		ss.Log = ls
+	case ls.File != id:
+		if old, err := os.Open(l.Path + ".1"); err == nil {
+			if oi, err := old.Stat(); err == nil && getFileID(oi) == ls.File {
+				scan(old, ls.Offset)
+			}
+			old.Close()
+		}
+		ls.File, ls.Offset = id, 0
+	case fi.Size() < ls.Offset:
+		ls.Offset = 0
+	}
+	ls.Offset, err = scan(f, ls.Offset)
+	if err != nil {
+		r.State = stateDown
+		r.Message = err.Error()
+		return
+	}
+
+	// Count matches within the window.
+	if matches > 0 {
+		ls.Matches = append(ls.Matches, &logCount{Time: now, Count: matches})
+	}
+	var recent []*logCount
+	total := 0
+	for _, m := range ls.Matches {
+		if now.Sub(m.Time) < time.Duration(*l.Window) {
+			recent = append(recent, m)
+			total += m.Count
+		}
+	}
+	ls.Matches = recent
+	ls.Lines = append(ls.Lines, lines...)
+	if len(ls.Lines) > l.MaxLines {
+		ls.Lines = ls.Lines[len(ls.Lines)-l.MaxLines:]
+	}
+	if total == 0 {
+		ls.Lines = nil
+	}
+
+	r.Metrics = []*metric{{
+		Name:  "matches",
+		Value: float64(total),
+		State: l.state(float64(total)),
+	}}
+	r.Lines = ls.Lines
+	r.State = r.Metrics[0].State
+	if r.State != stateUp {
+		r.Message = fmt.Sprintf("%d matching lines in %s: %s",
+			total, formatDuration(time.Duration(*l.Window)), strings.Join(ls.Lines, " | "))
+	}
+}
+
+// scan reads complete lines from f starting at offset, returning the
+// offset following the last complete line, and the lines matching
+// any of the check's patterns.
+func (l *logfileCheck) scan(f *os.File, offset int64) (int64, []string, error) {
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return offset, nil, err
+	}
+	var lines []string
+	br := bufio.NewReader(f)
+	for {
+		line, err := br.ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			// Leave any partially written line for the next check.
+			return offset, lines, nil
+		}
+		if err != nil {
+			return offset, lines, err
+		}
+		offset += int64(len(line))
+		line = strings.TrimRight(line, "\r\n")
+		for _, re := range l.res {
+			if re.MatchString(line) {
+				lines = append(lines, truncate(line, 200))
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// logfileService returns a logfile check of path counting lines
+// containing ERROR, degraded at one match and down at four.
+func logfileService(t *testing.T, path string) *service {
+	t.Helper()
+	cfg := newFakeNotifiers(t).config(t, `{}`, fmt.Sprintf(`{
+		"name": "app", "type": "logfile",
+		"logfile": {"path": %q, "patterns": ["ERROR"], "critical": 4, "max_lines": 3}
+	}`, path))
+	return cfg.Services[0]
+}
+
+// appendLog appends data to the file at path, creating it if needed.
+func appendLog(t *testing.T, path, data string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckLogfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	s := logfileService(t, path)
+	ss := &serviceState{}
+	check := func() *result {
+		r := &result{}
+		checkLogfile(s, ss, r)
+		return r
+	}
+
+	steps := []struct {
+		name    string
+		change  func()
+		state   string
+		matches float64
+		lines   []string
+	}{
+		{"missing", func() {}, stateDown, 0, nil},
+		// The existing contents of the file aren't read.
+		{"first check", func() { appendLog(t, path, "ERROR before\n") }, stateUp, 0, nil},
+		// A partially written line is left for the next check.
+		{"partial line", func() { appendLog(t, path, "INFO started\nERROR one\nERROR par") }, stateDegraded, 1, []string{
+			"ERROR one",
+		}},
+		{"line completed", func() { appendLog(t, path, "tial\r\nINFO ok\n") }, stateDegraded, 2, []string{
+			"ERROR one", "ERROR partial",
+		}},
+		// The remainder of the rotated file is read before the new
+		// file, which is read from its start.
+		{"rotated", func() {
+			appendLog(t, path, "ERROR before rotation\n")
+			if err := os.Rename(path, path+".1"); err != nil {
+				t.Fatal(err)
+			}
+			appendLog(t, path, "ERROR after rotation\n")
+		}, stateDown, 4, []string{
+			"ERROR partial", "ERROR before rotation", "ERROR after rotation",
+		}},
+		// A truncated file is read from its start.
+		{"truncated", func() {
+			if err := os.WriteFile(path, []byte("ERROR x\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}, stateDown, 5, []string{
+			"ERROR before rotation", "ERROR after rotation", "ERROR x",
+		}},
+		// Matches are forgotten once they leave the window.
+		{"expired", func() {
+			for _, m := range ss.Log.Matches[:len(ss.Log.Matches)-1] {
+				m.Time = m.Time.Add(-10 * time.Minute)
+			}
+		}, stateDegraded, 1, []string{
+			"ERROR before rotation", "ERROR after rotation", "ERROR x",
+		}},
+		{"all expired", func() {
+			for _, m := range ss.Log.Matches {
+				m.Time = m.Time.Add(-10 * time.Minute)
+			}
+		}, stateUp, 0, nil},
+		// A file rotated elsewhere is read from its start.
+		{"rotated away", func() {
+			if err := os.Rename(path, path+".old"); err != nil {
+				t.Fatal(err)
+			}
+			appendLog(t, path, "INFO new\nERROR new\n")
+		}, stateDegraded, 1, []string{
+			"ERROR new",
+		}},
+	}
+	for _, step := range steps {
+		step.change()
+		r := check()
+		if r.State != step.state || len(r.Metrics) > 0 && r.Metrics[0].Value != step.matches ||
+			strings.Join(r.Lines, "\n") != strings.Join(step.lines, "\n") {
+			t.Errorf("%s: %s %q %q, want %s with %g matches %q",
+				step.name, r.State, r.Message, r.Lines, step.state, step.matches, step.lines)
+		}
+		if step.name == "line completed" && r.Message != "2 matching lines in 10m0s: ERROR one | ERROR partial" {
+			t.Errorf("%s: message %q", step.name, r.Message)
+		}
+	}
+}
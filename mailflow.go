@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// mailflowCheck configures an end-to-end check of mail delivery. A
+// uniquely tagged message is sent via SMTP, and an IMAP mailbox is
+// polled until it arrives, after which it is deleted. Probes are also
+// tagged with the service, so that several services can share a
+// mailbox.
+type mailflowCheck struct {
+	SMTP *mailServer `json:"smtp"`
+	IMAP *mailServer `json:"imap"`
+	From string      `json:"from"`
+	To   string      `json:"to"`
+	// Timeout is how long to wait for the message to arrive before
+	// the service is down (default 2m).
+	Timeout *duration `json:"timeout,omitempty"`
+	// PollInterval is the time between searches of the mailbox
+	// (default 5s).
+	PollInterval *duration `json:"poll_interval,omitempty"`
+	// MaxLatency is the delivery time above which the service is
+	// degraded.
+	MaxLatency *duration `json:"max_latency,omitempty"`
+}
+
+// mailServer holds the details needed to connect to a mail server.
+type mailServer struct {
+	Address  string `json:"address"`
+	Username string `json:"username,omitempty"`
+	Password secret `json:"password,omitempty"`
+	// TLS is one of "tls", for implicit TLS, "starttls" or "none".
+	TLS string `json:"tls,omitempty"`
+	// Mailbox is the IMAP mailbox to search, "INBOX" by default.
+	Mailbox string `json:"mailbox,omitempty"`
+	// InsecureSkipVerify disables verification of the server's
+	// certificate.
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+}
+
+func (m *mailflowCheck) init() error {
+	if m.SMTP == nil || m.IMAP == nil || m.From == "" || m.To == "" {
+		return fmt.Errorf("mailflow check requires smtp, imap, from and to")
+	}
+	for _, ms := range []*mailServer{m.SMTP, m.IMAP} {
+		switch ms.TLS {
+		case "tls", "starttls", "none":
+		case "":
+			ms.TLS = "starttls"
+		default:
+			return fmt.Errorf("unknown tls mode %q", ms.TLS)
+		}
+	}
+	if m.IMAP.Mailbox == "" {
+		m.IMAP.Mailbox = "INBOX"
+	}
+	if m.Timeout == nil {
+		d := duration(2 * time.Minute)
+		m.Timeout = &d
+	}
+	if m.PollInterval == nil {
+		d := duration(5 * time.Second)
+		m.PollInterval = &d
+	}
+	return nil
+}
+
+// tlsConfig returns the TLS configuration for connecting to the
+// server.
+func (ms *mailServer) tlsConfig() *tls.Config {
+	host, _, _ := net.SplitHostPort(ms.Address)
+	return &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: ms.InsecureSkipVerify,
+	}
+}
+
+// checkMailflow sends a probe message and waits for it to arrive,
+// reporting the time taken as the "delivery" metric.
+func checkMailflow(s *service, _ *serviceState, r *result) {
+	m := s.Mailflow
+	b := make([]byte, 12)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+
+	id := probeID(s.Name)
+	if err := m.send(id, token); err != nil {
+		r.State = stateDown
+		r.Message = fmt.Sprintf("sending probe: %v", err)
+		return
+	}
+	sent := time.Now()
+
+	c, err := dialIMAP(m.IMAP)
+	if err != nil {
+		r.State = stateDown
+		r.Message = fmt.Sprintf("imap: %v", err)
+		return
+	}
+	defer c.close()
+
+	deadline := sent.Add(time.Duration(*m.Timeout))
+	for {
+		uids, err := c.search("X-Mon-Probe", token)
+		if err != nil {
+			r.State = stateDown
+			r.Message = fmt.Sprintf("imap: %v", err)
+			return
+		}
+		if len(uids) > 0 {
+			latency := time.Since(sent)
+			// Delete any probes delivered too late for earlier
+			// checks of the service along with this one.
+			if all, err := c.search("X-Mon-Service", id); err == nil && len(all) > 0 {
+				uids = all
+			}
+			if err := c.delete(uids); err != nil {
+				logger.Error("unable to delete mailflow probe",
+					"service", s.Name,
+					"error", err)
+			}
+			r.State = stateUp
+			r.Metrics = []*metric{{
+				Name:  "delivery",
+				Value: latency.Seconds(),
+				Unit:  "s",
+				State: stateUp,
+			}}
+			if m.MaxLatency != nil && latency > time.Duration(*m.MaxLatency) {
+				r.State = stateDegraded
+				r.Metrics[0].State = stateDegraded
+				r.Message = fmt.Sprintf("delivery took %s", latency.Round(time.Second))
+			}
+			return
+		}
+		if time.Now().Add(time.Duration(*m.PollInterval)).After(deadline) {
+			r.State = stateDown
+			r.Message = fmt.Sprintf("probe not delivered within %s", time.Duration(*m.Timeout))
+			return
+		}
+		time.Sleep(time.Duration(*m.PollInterval))
+	}
+}
+
+// probeID returns the identifier of the service in its probes. It is
+// a fixed length hash of the service's name, so that IMAP searches,
+// which match substrings, can't match the probes of another service.
+func probeID(name string) string {
+	h := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(h[:8])
+}
+
+// send sends a probe message tagged with the service's id and token.
+func (m *mailflowCheck) send(id, token string) error {
+	ms := m.SMTP
+	var c *smtp.Client
+	if ms.TLS == "tls" {
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", ms.Address, ms.tlsConfig())
+		if err != nil {
+			return err
+		}
+		host, _, _ := net.SplitHostPort(ms.Address)
+		if c, err = smtp.NewClient(conn, host); err != nil {
+			conn.Close()
+			return err
+		}
+	} else {
+		conn, err := net.DialTimeout("tcp", ms.Address, 10*time.Second)
+		if err != nil {
+			return err
+		}
+		host, _, _ := net.SplitHostPort(ms.Address)
+		if c, err = smtp.NewClient(conn, host); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	defer c.Close()
+
+	if ms.TLS == "starttls" {
+		if err := c.StartTLS(ms.tlsConfig()); err != nil {
+			return err
+		}
+	}
+	if ms.Username != "" {
+		password, err := ms.Password.value()
+		if err != nil {
+			return err
+		}
+		host, _, _ := net.SplitHostPort(ms.Address)
+		if err := c.Auth(smtp.PlainAuth("", ms.Username, password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(m.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(m.To); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "From: %s\r\n", m.From)
+	fmt.Fprintf(w, "To: %s\r\n", m.To)
+	fmt.Fprintf(w, "Subject: mon mailflow probe %s\r\n", token)
+	fmt.Fprintf(w, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(w, "Message-ID: <%s@mon>\r\n", token)
+	fmt.Fprintf(w, "X-Mon-Probe: %s\r\n", token)
+	fmt.Fprintf(w, "X-Mon-Service: %s\r\n", id)
+	fmt.Fprintf(w, "\r\nThis message was sent by mon to check mail delivery.\r\n")
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// imapClient is a minimal IMAP4rev1 client, supporting only the
+// commands needed to find and delete probe messages.
+type imapClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+}
+
+// dialIMAP connects and logs in to the IMAP server, and selects the
+// configured mailbox.
+func dialIMAP(ms *mailServer) (*imapClient, error) {
+	password, err := ms.Password.value()
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if ms.TLS == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", ms.Address, ms.tlsConfig())
+	} else {
+		conn, err = dialer.Dial("tcp", ms.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &imapClient{conn: conn, r: bufio.NewReader(conn)}
+	greeting, err := c.readLine()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(greeting, "* OK") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected greeting %q", greeting)
+	}
+	if ms.TLS == "starttls" {
+		if _, err := c.command("STARTTLS"); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		c.conn = tls.Client(conn, ms.tlsConfig())
+		c.r = bufio.NewReader(c.conn)
+	}
+	if _, err := c.command("LOGIN %s %s", imapQuote(ms.Username), imapQuote(password)); err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	if _, err := c.command("SELECT %s", imapQuote(ms.Mailbox)); err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// imapQuote returns s as an IMAP quoted string.
+func imapQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+func (c *imapClient) readLine() (string, error) {
+	c.conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+	line, err := c.r.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// command sends a command, returning the untagged responses received
+// before its tagged completion. It is an error if the command does
+// not complete with OK.
+func (c *imapClient) command(format string, args ...any) ([]string, error) {
+	c.tag++
+	tag := fmt.Sprintf("m%d", c.tag)
+	cmd := fmt.Sprintf(format, args...)
+	c.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	if _, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, cmd); err != nil {
+		return nil, err
+	}
+	var untagged []string
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if rest, ok := strings.CutPrefix(line, tag+" "); ok {
+			if !strings.HasPrefix(rest, "OK") {
+				verb, _, _ := strings.Cut(cmd, " ")
+				return nil, fmt.Errorf("%s: %s", verb, rest)
+			}
+			return untagged, nil
+		}
+		untagged = append(untagged, line)
+	}
+}
+
+// search returns the UIDs of messages whose header contains value.
+func (c *imapClient) search(header, value string) ([]string, error) {
+	// NOOP allows the server to report newly arrived messages.
+	if _, err := c.command("NOOP"); err != nil {
+		return nil, err
+	}
+	lines, err := c.command("UID SEARCH HEADER %s %s", header, imapQuote(value))
+	if err != nil {
+		return nil, err
+	}
+	var uids []string
+	for _, line := range lines {
+		if rest, ok := strings.CutPrefix(line, "* SEARCH"); ok {
+			uids = append(uids, strings.Fields(rest)...)
+		}
+	}
+	return uids, nil
+}
+
+// delete flags the messages with the given UIDs as deleted, and
+// expunges them. On servers without the UIDPLUS extension, the
+// messages are left flagged, as expunging the mailbox would also
+// remove any other messages flagged as deleted.
+func (c *imapClient) delete(uids []string) error {
+	set := strings.Join(uids, ",")
+	if _, err := c.command(`UID STORE %s +FLAGS.SILENT (\Deleted)`, set); err != nil {
+		return err
+	}
+	c.command("UID EXPUNGE %s", set)
+	return nil
+}
+
+func (c *imapClient) close() {
+	c.command("LOGOUT")
+	c.conn.Close()
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMessage is a message in a fakeMailbox.
+type fakeMessage struct {
+	uid     int
+	header  textproto.MIMEHeader
+	deleted bool
+}
+
+// fakeMailbox is a mailbox shared by a fake SMTP server, which
+// delivers to it, and a fake IMAP server, which serves it.
+type fakeMailbox struct {
+	mu       sync.Mutex
+	messages []*fakeMessage
+	nextUID  int
+	// drop discards delivered messages.
+	drop bool
+	// noUIDPlus rejects UID EXPUNGE, as servers without the UIDPLUS
+	// extension do.
+	noUIDPlus bool
+	// expunged is set if the whole mailbox was expunged.
+	expunged bool
+}
+
+// add adds a message with the given header fields, given as pairs of
+// names and values.
+func (mb *fakeMailbox) add(deleted bool, fields ...string) *fakeMessage {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.nextUID++
+	m := &fakeMessage{uid: mb.nextUID, header: make(textproto.MIMEHeader), deleted: deleted}
+	for i := 0; i < len(fields); i += 2 {
+		m.header.Add(fields[i], fields[i+1])
+	}
+	mb.messages = append(mb.messages, m)
+	return m
+}
+
+func (mb *fakeMailbox) contains(m *fakeMessage) bool {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	for _, n := range mb.messages {
+		if n == m {
+			return true
+		}
+	}
+	return false
+}
+
+func (mb *fakeMailbox) probes() []*fakeMessage {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	var probes []*fakeMessage
+	for _, m := range mb.messages {
+		if m.header.Get("X-Mon-Probe") != "" {
+			probes = append(probes, m)
+		}
+	}
+	return probes
+}
+
+// serve accepts connections on a new local listener, handling each
+// with handle, and returns the listener's address.
+func serve(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// serveSMTP runs a fake SMTP server delivering to the mailbox.
+func (mb *fakeMailbox) serveSMTP(conn net.Conn) {
+	tc := textproto.NewConn(conn)
+	tc.PrintfLine("220 fake ESMTP")
+	for {
+		line, err := tc.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, _, _ := strings.Cut(strings.ToUpper(line), " ")
+		switch verb {
+		case "EHLO", "HELO":
+			tc.PrintfLine("250 fake")
+		case "MAIL", "RCPT", "RSET", "NOOP":
+			tc.PrintfLine("250 OK")
+		case "DATA":
+			tc.PrintfLine("354 go ahead")
+			r := textproto.NewReader(bufio.NewReader(tc.DotReader()))
+			header, err := r.ReadMIMEHeader()
+			if err != nil {
+				return
+			}
+			// Drain the body.
+			for {
+				if _, err := r.ReadLine(); err != nil {
+					break
+				}
+			}
+			if !mb.drop {
+				mb.mu.Lock()
+				mb.nextUID++
+				mb.messages = append(mb.messages, &fakeMessage{uid: mb.nextUID, header: header})
+				mb.mu.Unlock()
+			}
+			tc.PrintfLine("250 queued")
+		case "QUIT":
+			tc.PrintfLine("221 bye")
+			return
+		default:
+			tc.PrintfLine("502 unknown command")
+		}
+	}
+}
+
+// serveIMAP runs a fake IMAP server serving the mailbox, supporting
+// the commands used by imapClient.
+func (mb *fakeMailbox) serveIMAP(conn net.Conn) {
+	tc := textproto.NewConn(conn)
+	tc.PrintfLine("* OK fake IMAP4rev1 ready")
+	for {
+		line, err := tc.ReadLine()
+		if err != nil {
+			return
+		}
+		tag, cmd, _ := strings.Cut(line, " ")
+		fields := strings.Fields(cmd)
+		if len(fields) == 0 {
+			tc.PrintfLine("%s BAD empty command", tag)
+			continue
+		}
+		verb := strings.ToUpper(fields[0])
+		if verb == "UID" && len(fields) > 1 {
+			verb += " " + strings.ToUpper(fields[1])
+		}
+		switch verb {
+		case "LOGIN", "SELECT", "NOOP":
+			tc.PrintfLine("%s OK %s completed", tag, verb)
+		case "LOGOUT":
+			tc.PrintfLine("* BYE")
+			tc.PrintfLine("%s OK LOGOUT completed", tag)
+			return
+		case "UID SEARCH":
+			// UID SEARCH HEADER <name> "<value>"
+			if len(fields) < 5 || strings.ToUpper(fields[2]) != "HEADER" {
+				tc.PrintfLine("%s BAD unsupported search", tag)
+				continue
+			}
+			_, value, _ := strings.Cut(cmd, `"`)
+			value = strings.TrimSuffix(value, `"`)
+			var uids []string
+			mb.mu.Lock()
+			for _, m := range mb.messages {
+				if value != "" && strings.Contains(m.header.Get(fields[3]), value) {
+					uids = append(uids, strconv.Itoa(m.uid))
+				}
+			}
+			mb.mu.Unlock()
+			tc.PrintfLine("* SEARCH %s", strings.Join(uids, " "))
+			tc.PrintfLine("%s OK SEARCH completed", tag)
+		case "UID STORE":
+			set := uidSet(fields[2])
+			mb.mu.Lock()
+			for _, m := range mb.messages {
+				if set[m.uid] {
+					m.deleted = true
+				}
+			}
+			mb.mu.Unlock()
+			tc.PrintfLine("%s OK STORE completed", tag)
+		case "UID EXPUNGE":
+			if mb.noUIDPlus {
+				tc.PrintfLine("%s BAD unknown command", tag)
+				continue
+			}
+			mb.expungeWhere(func(m *fakeMessage) bool {
+				return uidSet(fields[2])[m.uid]
+			})
+			tc.PrintfLine("%s OK EXPUNGE completed", tag)
+		case "EXPUNGE":
+			mb.expungeWhere(func(*fakeMessage) bool { return true })
+			mb.mu.Lock()
+			mb.expunged = true
+			mb.mu.Unlock()
+			tc.PrintfLine("%s OK EXPUNGE completed", tag)
+		default:
+			tc.PrintfLine("%s BAD unknown command", tag)
+		}
+	}
+}
+
+// expungeWhere removes the deleted messages for which f is true.
+func (mb *fakeMailbox) expungeWhere(f func(*fakeMessage) bool) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	var keep []*fakeMessage
+	for _, m := range mb.messages {
+		if !m.deleted || !f(m) {
+			keep = append(keep, m)
+		}
+	}
+	mb.messages = keep
+}
+
+// uidSet parses a comma separated list of UIDs.
+func uidSet(s string) map[int]bool {
+	set := make(map[int]bool)
+	for _, u := range strings.Split(s, ",") {
+		n, _ := strconv.Atoi(u)
+		set[n] = true
+	}
+	return set
+}
+
+// mailflowService returns a mailflow service named name, using fake
+// servers for mb.
+func mailflowService(t *testing.T, name string, mb *fakeMailbox) *service {
+	t.Helper()
+	timeout := duration(2 * time.Second)
+	poll := duration(50 * time.Millisecond)
+	m := &mailflowCheck{
+		SMTP:         &mailServer{Address: serve(t, mb.serveSMTP), TLS: "none"},
+		IMAP:         &mailServer{Address: serve(t, mb.serveIMAP), TLS: "none", Username: "mon", Password: "secret"},
+		From:         "mon@example.com",
+		To:           "probe@example.com",
+		Timeout:      &timeout,
+		PollInterval: &poll,
+	}
+	if err := m.init(); err != nil {
+		t.Fatal(err)
+	}
+	return &service{Name: name, Type: "mailflow", Mailflow: m}
+}
+
+func TestMailflow(t *testing.T) {
+	mb := &fakeMailbox{}
+	// A late probe from an earlier check of the service, a probe of
+	// another service sharing the mailbox, and a message the user
+	// has flagged as deleted.
+	late := mb.add(false, "X-Mon-Probe", "0123", "X-Mon-Service", probeID("relay"))
+	other := mb.add(false, "X-Mon-Probe", "4567", "X-Mon-Service", probeID("relay-2"))
+	flagged := mb.add(true, "Subject", "keep me")
+
+	s := mailflowService(t, "relay", mb)
+	r := &result{}
+	checkMailflow(s, nil, r)
+
+	if r.State != stateUp {
+		t.Fatalf("state = %q (%s), want up", r.State, r.Message)
+	}
+	if len(r.Metrics) != 1 || r.Metrics[0].Name != "delivery" {
+		t.Errorf("metrics = %v, want delivery", r.Metrics)
+	}
+	if mb.contains(late) {
+		t.Error("late probe of the service was not deleted")
+	}
+	if !mb.contains(other) {
+		t.Error("probe of another service was deleted")
+	}
+	if !mb.contains(flagged) {
+		t.Error("message flagged as deleted by the user was expunged")
+	}
+	if probes := mb.probes(); len(probes) != 1 || probes[0] != other {
+		t.Errorf("%d probes left in mailbox, want only the other service's", len(probes))
+	}
+}
+
+func TestMailflowSharedMailbox(t *testing.T) {
+	mb := &fakeMailbox{}
+	services := []*service{
+		mailflowService(t, "relay", mb),
+		mailflowService(t, "relay-2", mb),
+	}
+	results := make([]*result, len(services))
+	var wg sync.WaitGroup
+	for i, s := range services {
+		results[i] = &result{}
+		wg.Add(1)
+		go func(s *service, r *result) {
+			defer wg.Done()
+			checkMailflow(s, nil, r)
+		}(s, results[i])
+	}
+	wg.Wait()
+	for i, r := range results {
+		if r.State != stateUp {
+			t.Errorf("%s: state = %q (%s), want up", services[i].Name, r.State, r.Message)
+		}
+	}
+	if probes := mb.probes(); len(probes) != 0 {
+		t.Errorf("%d probes left in mailbox, want 0", len(probes))
+	}
+}
+
+func TestMailflowWithoutUIDPlus(t *testing.T) {
+	mb := &fakeMailbox{noUIDPlus: true}
+	flagged := mb.add(true, "Subject", "keep me")
+
+	s := mailflowService(t, "relay", mb)
+	r := &result{}
+	checkMailflow(s, nil, r)
+
+	if r.State != stateUp {
+		t.Fatalf("state = %q (%s), want up", r.State, r.Message)
+	}
+	mb.mu.Lock()
+	expunged := mb.expunged
+	mb.mu.Unlock()
+	if expunged {
+		t.Error("mailbox was expunged")
+	}
+	if !mb.contains(flagged) {
+		t.Error("message flagged as deleted by the user was expunged")
+	}
+	probes := mb.probes()
+	if len(probes) != 1 || !probes[0].deleted {
+		t.Errorf("probes = %d, want 1 flagged as deleted", len(probes))
+	}
+}
+
+func TestMailflowNotDelivered(t *testing.T) {
+	mb := &fakeMailbox{drop: true}
+	s := mailflowService(t, "relay", mb)
+	d := duration(300 * time.Millisecond)
+	s.Mailflow.Timeout = &d
+	r := &result{}
+	checkMailflow(s, nil, r)
+
+	if r.State != stateDown {
+		t.Errorf("state = %q, want down", r.State)
+	}
+	if want := fmt.Sprintf("probe not delivered within %s", time.Duration(d)); r.Message != want {
+		t.Errorf("message = %q, want %q", r.Message, want)
+	}
+}
+
+func TestMailflowSMTPDown(t *testing.T) {
+	mb := &fakeMailbox{}
+	s := mailflowService(t, "relay", mb)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Mailflow.SMTP.Address = l.Addr().String()
+	l.Close()
+
+	r := &result{}
+	checkMailflow(s, nil, r)
+	if r.State != stateDown || !strings.HasPrefix(r.Message, "sending probe:") {
+		t.Errorf("result = %q %q, want down sending probe", r.State, r.Message)
+	}
+}
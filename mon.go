@@ -13,11 +13,20 @@ where [config_dir] is whatever os.UserConfigDir() returns. This
 can be overriden with the -s/-services-file flags.
 
 mon can output status results in tabular format (the default), as JSON
-or as a MacOS notification for 'failing' services.
+or as notifications for 'failing' services. Notifications are routed
+to notifiers through a routing tree defined in the configuration file;
+by default, each failing service results in a MacOS notification.
 
 Usage:
 
   mon [flags]
+  mon [flags] routes test <service> [label=value...]
+  mon [flags] slo
+
+The routes test command prints the notifiers that would be notified
+of a failure of the given service. The slo command prints the error
+budget remaining for each service level objective, computed from the
+history of checks kept in mon's state directory.
 
 The flags are:
 
@@ -25,8 +34,12 @@ The flags are:
       Full path including filename to the configuration file
   -j,-json
       Output results in JSON format
+  -format
+      Output results in the given format: table, json, health+json,
+      statuspage-summary or statuspage-status
   -notify
-      Display a MacOS notification for failing services via osascript
+      Send notifications for failing services, outputting results
+      only if -json or -format is also given
 */
 package main
 
@@ -36,19 +49,20 @@ import (
 	"fmt"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"sync"
 	"text/tabwriter"
 	"time"
 
 	"log/slog"
 )
 
+var logger = slog.New(slog.NewJSONHandler(os.Stderr, nil))
+
 func main() {
 	var (
 		file   string
 		asJson bool
+		format string
 		notify bool
 	)
 
@@ -56,10 +70,21 @@ func main() {
 	flag.StringVar(&file, "services-file", "", "full path to services file")
 	flag.BoolVar(&asJson, "j", false, "whether to display output as JSON")
 	flag.BoolVar(&asJson, "json", false, "whether to display output as JSON")
+	flag.StringVar(&format, "format", "", "output format: table (the default), json, health+json, statuspage-summary or statuspage-status")
 	flag.BoolVar(&notify, "notify", false, "whether to display service issues as notifications")
 	flag.Parse()
-
-	logger := slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	if asJson {
+		format = "json"
+	}
+	if format == "" && !notify {
+		format = "table"
+	}
+	switch format {
+	case "", "table", "json", "health+json", "statuspage-summary", "statuspage-status":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown output format %q\n", format)
+		os.Exit(2)
+	}
 
 	if file == "" {
 		dir, err := getConfigDir()
@@ -78,18 +103,8 @@ func main() {
 		os.Exit(1)
 	}
 
-	// service represents a service definition from the configuration file.
-	// It is also used for output.
-	type service struct {
-		Name    string            `json:"name"`
-		URL     string            `json:"url"`
-		Headers map[string]string `json:"headers,omitempty"`
-		Status  int               `json:"status"`
-	}
-
 	// Read contents of services file.
-	var services []*service
-	err = json.Unmarshal(data, &services)
+	cfg, err := parseConfig(data, filepath.Dir(file))
 	if err != nil {
 		logger.Error("unable to parse services file",
 			"file", file,
@@ -97,71 +112,117 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Attempt to get all specfied URLs.
-	var wg sync.WaitGroup
-	wg.Add(len(services))
-	for _, svc := range services {
-		go func(s *service) {
-			defer wg.Done()
-			client := http.Client{
-				Timeout: 2 * time.Second,
-			}
-			req, err := http.NewRequest(http.MethodGet, s.URL, nil)
-			if err != nil {
-				logger.Error("error creating new request",
-					"url", s.URL,
-					"error", err)
-				return
-			}
-			if s.Headers != nil {
-				for k, v := range s.Headers {
-					req.Header.Add(k, v)
-				}
-			}
-			resp, err := client.Do(req)
-			if err != nil {
-				logger.Error("error getting URL",
-					"url", s.URL,
-					"error", err)
-				// Server error response OK for now; just need
-				// to indicate a problem.
-				s.Status = http.StatusServiceUnavailable
-				return
-			}
-			s.Status = resp.StatusCode
-		}(svc)
+	dir, err := getStateDir()
+	if err != nil {
+		logger.Error("unable to obtain state directory",
+			"error", err)
+		os.Exit(1)
 	}
-	wg.Wait()
-
-	// Output results.
-	switch {
-	case asJson:
-		b, err := json.Marshal(services)
+	now := time.Now()
+
+	// Run any command given instead of checking services.
+	if flag.NArg() > 0 {
+		switch cmd := flag.Arg(0); cmd {
+		case "routes":
+			err = routesCommand(cfg, flag.Args()[1:])
+		case "slo":
+			err = sloCommand(cfg, dir, now)
+		default:
+			err = fmt.Errorf("unknown command %q", cmd)
+		}
 		if err != nil {
-			logger.Error("unable to marshal responses", "error", err)
-			os.Exit(1)
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
 		}
-		fmt.Printf("%s", string(b))
-	case notify:
-		for _, s := range services {
-			if s.Status != http.StatusOK {
-				n := fmt.Sprintf("display notification \"%s\" with title \"%s\"",
-					http.StatusText(s.Status), s.Name)
-				err := exec.Command("osascript", "-e", n).Run()
-				if err != nil {
-					logger.Error("could not execute 'osascript': %s\n",
-						"error", err)
-					os.Exit(1)
-				}
+		return
+	}
+
+	st, err := loadState(dir)
+	if err != nil {
+		logger.Error("unable to load state",
+			"error", err)
+		os.Exit(1)
+	}
+
+	// Attempt to get all specfied URLs, and act on the results of
+	// the services checked.
+	results := checkServices(cfg.Services, st, now)
+	checked := fresh(results)
+	detectAnomalies(checked, st)
+	diagnose(cfg.Diagnostics, checked)
+	countFailures(checked, st)
+	remediate(checked, st, now)
+	keepResults(checked, st)
+	setIntervals(results, st)
+
+	// Record results in the check history, pruning it daily.
+	if err := appendHistory(dir, checked, now); err != nil {
+		logger.Error("unable to record check history",
+			"error", err)
+	}
+	if now.Sub(st.HistoryPruned) > 24*time.Hour {
+		if err := pruneHistory(dir, now.Add(-sloWindow(cfg.Services))); err != nil {
+			logger.Error("unable to prune check history",
+				"error", err)
+		}
+		st.HistoryPruned = now
+	}
+
+	// Send notifications, and output results.
+	if notify {
+		var alerts []*alert
+		for _, r := range results {
+			if r.State != stateUp && r.service.active(now) {
+				a := newAlert(r.service, "state", r.State, r.summary())
+				a.remediated = r.Remediation != nil && !r.Stale
+				alerts = append(alerts, a)
 			}
 		}
-	default:
+		records, err := readHistory(dir, now.Add(-burnWindows[len(burnWindows)-1].Long))
+		if err != nil {
+			logger.Error("unable to read check history",
+				"error", err)
+		}
+		alerts = append(alerts, burnAlerts(cfg.Services, results, records, now)...)
+		dispatch(cfg, st, alerts, now)
+	}
+	switch format {
+	case "":
+	case "table":
 		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.StripEscape)
-		fmt.Fprintln(w, "SERVICE\tURL\tSTATUS")
-		for _, s := range services {
-			fmt.Fprintf(w, "%s\t%s\t%s\n", s.Name, s.URL, http.StatusText(s.Status))
+		fmt.Fprintln(w, "SERVICE\tURL\tSTATUS\tSTATE")
+		for _, r := range results {
+			// Prefer any message explaining the service's
+			// state to its status code.
+			status := r.Message
+			if status == "" {
+				status = http.StatusText(r.Status)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Name, r.URL, status, r.State)
 		}
 		w.Flush()
+	default:
+		var v any = results
+		switch format {
+		case "health+json":
+			v = newHealth(results)
+		case "statuspage-summary":
+			v = newPageSummary(results, now)
+		case "statuspage-status":
+			v = newPageSummary(results, now).pageStatus
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			logger.Error("unable to marshal responses", "error", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s", string(b))
+	}
+
+	if err := st.save(); err != nil {
+		logger.Error("unable to save state",
+			"error", err)
+		os.Exit(1)
 	}
 }
 
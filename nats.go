@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// natsCheck configures a check of a NATS server. The check connects
+// and pings the server, and optionally sends a request on a subject
+// and waits for a reply.
+type natsCheck struct {
+	// Address is the server's host and port. The port defaults to
+	// 4222.
+	Address string `json:"address"`
+	// TLS is one of "tls" or "none" (the default). TLS is used
+	// regardless if the server requires it.
+	TLS string `json:"tls,omitempty"`
+	// InsecureSkipVerify disables verification of the server's
+	// certificate.
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+	// Username and Password, or Token, authenticate the connection.
+	Username string `json:"username,omitempty"`
+	Password secret `json:"password,omitempty"`
+	Token    secret `json:"token,omitempty"`
+
+	// Subject, if set, is sent a request containing Payload, which
+	// must be replied to.
+	Subject string `json:"subject,omitempty"`
+	Payload string `json:"payload,omitempty"`
+
+	// Timeout limits the whole check (default 10s).
+	Timeout *duration `json:"timeout,omitempty"`
+	// MaxLatency is the ping or request time above which the
+	// service is degraded.
+	MaxLatency *duration `json:"max_latency,omitempty"`
+}
+
+func (c *natsCheck) init() error {
+	if c.Address == "" {
+		return fmt.Errorf("nats check requires an address")
+	}
+	switch c.TLS {
+	case "tls", "none":
+	case "":
+		c.TLS = "none"
+	default:
+		return fmt.Errorf("unknown tls mode %q", c.TLS)
+	}
+	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+		c.Address = net.JoinHostPort(c.Address, "4222")
+	}
+	if c.Timeout == nil {
+		d := duration(10 * time.Second)
+		c.Timeout = &d
+	}
+	return nil
+}
+
+// checkNATS connects to the server, reporting the time taken to
+// connect as the "connect" metric, the round trip time of a ping as
+// the "ping" metric, and the time taken for a request to be replied
+// to as the "request" metric.
+func checkNATS(s *service, _ *serviceState, r *result) {
+	c := s.NATS
+	r.URL = "nats://" + c.Address
+	if c.TLS == "tls" {
+		r.URL = "tls://" + c.Address
+	}
+
+	start := time.Now()
+	conn, err := c.dial()
+	if err != nil {
+		r.State = stateDown
+		r.Message = err.Error()
+		return
+	}
+	defer conn.conn.Close()
+	r.Metrics = append(r.Metrics, latencyMetric("connect", time.Since(start), c.MaxLatency))
+
+	start = time.Now()
+	if err := conn.ping(); err != nil {
+		r.State = stateDown
+		r.Message = fmt.Sprintf("ping: %v", err)
+		return
+	}
+	r.Metrics = append(r.Metrics, latencyMetric("ping", time.Since(start), c.MaxLatency))
+
+	if c.Subject != "" {
+		start = time.Now()
+		if err := conn.request(c.Subject, c.Payload); err != nil {
+			r.State = stateDown
+			r.Message = fmt.Sprintf("request: %v", err)
+			return
+		}
+		r.Metrics = append(r.Metrics, latencyMetric("request", time.Since(start), c.MaxLatency))
+	}
+
+	r.State = stateUp
+	for _, m := range r.Metrics {
+		r.State = worse(r.State, m.State)
+	}
+	if r.State != stateUp {
+		r.Message = "slow response"
+	}
+}
+
+// natsInfo is the subset of the server's INFO message used.
+type natsInfo struct {
+	TLSRequired bool `json:"tls_required"`
+	Headers     bool `json:"headers"`
+}
+
+// natsConn is a minimal NATS client connection, supporting only
+// pings and requests.
+type natsConn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	headers bool
+}
+
+// dial connects to the server, upgrading the connection to TLS if
+// configured or required, and authenticates.
+func (c *natsCheck) dial() (*natsConn, error) {
+	conn, err := net.DialTimeout("tcp", c.Address, time.Duration(*c.Timeout))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(time.Duration(*c.Timeout)))
+	nc := &natsConn{conn: conn, r: bufio.NewReader(conn)}
+	line, err := nc.readLine()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	info, ok := strings.CutPrefix(line, "INFO ")
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected greeting %q", truncate(line, 64))
+	}
+	var si natsInfo
+	if err := json.Unmarshal([]byte(info), &si); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("invalid INFO: %w", err)
+	}
+	nc.headers = si.Headers
+
+	useTLS := c.TLS == "tls" || si.TLSRequired
+	if useTLS {
+		host, _, _ := net.SplitHostPort(c.Address)
+		tc := tls.Client(conn, &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: c.InsecureSkipVerify,
+		})
+		if err := tc.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		nc.conn = tc
+		nc.r = bufio.NewReader(tc)
+	}
+
+	opts := map[string]any{
+		"verbose":      false,
+		"pedantic":     false,
+		"tls_required": useTLS,
+		"name":         "mon",
+		"lang":         "go",
+		"version":      "1",
+		"protocol":     1,
+	}
+	if nc.headers {
+		// Requests to subjects without subscribers are then
+		// answered immediately, rather than timing out.
+		opts["headers"] = true
+		opts["no_responders"] = true
+	}
+	if c.Username != "" {
+		password, err := c.Password.value()
+		if err != nil {
+			nc.conn.Close()
+			return nil, err
+		}
+		opts["user"], opts["pass"] = c.Username, password
+	}
+	if c.Token != "" {
+		token, err := c.Token.value()
+		if err != nil {
+			nc.conn.Close()
+			return nil, err
+		}
+		opts["auth_token"] = token
+	}
+	b, _ := json.Marshal(opts)
+	if _, err := fmt.Fprintf(nc.conn, "CONNECT %s\r\n", b); err != nil {
+		nc.conn.Close()
+		return nil, err
+	}
+	// Errors in CONNECT, such as authorization failures, are
+	// reported in reply to the first ping.
+	if err := nc.ping(); err != nil {
+		nc.conn.Close()
+		return nil, err
+	}
+	return nc, nil
+}
+
+// ping sends a ping and waits for the server's pong.
+func (nc *natsConn) ping() error {
+	if _, err := io.WriteString(nc.conn, "PING\r\n"); err != nil {
+		return err
+	}
+	for {
+		line, err := nc.next()
+		if err != nil {
+			return err
+		}
+		if line == "PONG" {
+			return nil
+		}
+	}
+}
+
+// request publishes payload to subject with a unique reply subject,
+// and waits for a reply.
+func (nc *natsConn) request(subject, payload string) error {
+	b := make([]byte, 12)
+	rand.Read(b)
+	inbox := "_INBOX.mon." + hex.EncodeToString(b)
+	_, err := fmt.Fprintf(nc.conn, "SUB %s 1\r\nPUB %s %s %d\r\n%s\r\n",
+		inbox, subject, inbox, len(payload), payload)
+	if err != nil {
+		return err
+	}
+	for {
+		line, err := nc.next()
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return fmt.Errorf("no reply on %s", subject)
+		}
+		if err != nil {
+			return err
+		}
+		args := strings.Fields(line)
+		switch {
+		case len(args) == 4 && args[0] == "MSG":
+			_, err := nc.body(args[3])
+			return err
+		case len(args) == 5 && args[0] == "HMSG":
+			msg, err := nc.body(args[4])
+			if err != nil {
+				return err
+			}
+			n, _ := strconv.Atoi(args[3])
+			if status, _, _ := strings.Cut(msg[:min(n, len(msg))], "\r\n"); strings.HasPrefix(status, "NATS/1.0 503") {
+				return fmt.Errorf("no responders on %s", subject)
+			}
+			return nil
+		}
+	}
+}
+
+// body reads a message body of the given size.
+func (nc *natsConn) body(size string) (string, error) {
+	n, err := strconv.Atoi(size)
+	if err != nil || n < 0 || n > 64<<20 {
+		return "", fmt.Errorf("invalid message size %q", size)
+	}
+	b := make([]byte, n+2)
+	if _, err := io.ReadFull(nc.r, b); err != nil {
+		return "", err
+	}
+	return string(b[:n]), nil
+}
+
+// next reads the next protocol line, answering server pings and
+// returning server errors.
+func (nc *natsConn) next() (string, error) {
+	for {
+		line, err := nc.readLine()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case line == "PING":
+			if _, err := io.WriteString(nc.conn, "PONG\r\n"); err != nil {
+				return "", err
+			}
+		case line == "+OK", strings.HasPrefix(line, "INFO "):
+		case strings.HasPrefix(line, "-ERR"):
+			msg := strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")), "'")
+			return "", fmt.Errorf("%s", msg)
+		default:
+			return line, nil
+		}
+	}
+}
+
+func (nc *natsConn) readLine() (string, error) {
+	line, err := nc.r.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNATS is a fake NATS server, supporting the protocol used by
+// natsConn.
+type fakeNATS struct {
+	username, password string
+	// headers is advertised in the server's INFO.
+	headers bool
+	// responders are the subjects with subscribers, which reply to
+	// requests with the request's payload.
+	responders map[string]bool
+}
+
+func (s *fakeNATS) serve(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	info, _ := json.Marshal(map[string]any{"server_id": "fake", "headers": s.headers})
+	fmt.Fprintf(conn, "INFO %s\r\n", info)
+	authorized := false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		switch args[0] {
+		case "CONNECT":
+			var opts struct {
+				User string `json:"user"`
+				Pass string `json:"pass"`
+			}
+			json.Unmarshal([]byte(strings.TrimPrefix(line, "CONNECT ")), &opts)
+			authorized = opts.User == s.username && opts.Pass == s.password
+			io.WriteString(conn, "+OK\r\n")
+		case "PING":
+			if !authorized {
+				io.WriteString(conn, "-ERR 'Authorization Violation'\r\n")
+				return
+			}
+			io.WriteString(conn, "PONG\r\n")
+		case "SUB":
+		case "PUB":
+			// PUB <subject> <reply> <size>
+			if len(args) != 4 {
+				return
+			}
+			n, _ := strconv.Atoi(args[3])
+			payload := make([]byte, n+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			// Ping the client before replying, as servers do
+			// from time to time.
+			io.WriteString(conn, "PING\r\n")
+			switch {
+			case s.responders[args[1]]:
+				fmt.Fprintf(conn, "MSG %s 1 %d\r\n%s", args[2], n, payload)
+			case s.headers:
+				const status = "NATS/1.0 503\r\n\r\n"
+				fmt.Fprintf(conn, "HMSG %s 1 %d %d\r\n%s\r\n", args[2], len(status), len(status), status)
+			}
+		case "PONG":
+		default:
+			io.WriteString(conn, "-ERR 'Unknown Protocol Operation'\r\n")
+			return
+		}
+	}
+}
+
+// natsService returns a nats service checking a server served by
+// handle, sending requests to subject.
+func natsService(t *testing.T, handle func(net.Conn), subject string) *service {
+	t.Helper()
+	timeout := duration(500 * time.Millisecond)
+	c := &natsCheck{
+		Address:  serve(t, handle),
+		Username: "mon",
+		Password: "secret",
+		Subject:  subject,
+		Payload:  "ping",
+		Timeout:  &timeout,
+	}
+	if err := c.init(); err != nil {
+		t.Fatal(err)
+	}
+	return &service{Name: "nats", Type: "nats", NATS: c}
+}
+
+func TestNATS(t *testing.T) {
+	for _, headers := range []bool{false, true} {
+		s := &fakeNATS{username: "mon", password: "secret", headers: headers, responders: map[string]bool{"health": true}}
+		r := &result{}
+		checkNATS(natsService(t, s.serve, "health"), nil, r)
+		if r.State != stateUp {
+			t.Fatalf("headers %v: state = %q (%s), want up", headers, r.State, r.Message)
+		}
+		var names []string
+		for _, m := range r.Metrics {
+			names = append(names, m.Name)
+		}
+		if got := strings.Join(names, " "); got != "connect ping request" {
+			t.Errorf("headers %v: metrics = %s, want connect ping request", headers, got)
+		}
+	}
+}
+
+func TestNATSErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handle  func(net.Conn)
+		subject string
+		want    string
+	}{
+		{
+			"bad credentials",
+			(&fakeNATS{username: "mon", password: "other"}).serve,
+			"",
+			"Authorization Violation",
+		},
+		{
+			"no responders",
+			(&fakeNATS{username: "mon", password: "secret", headers: true}).serve,
+			"health",
+			"request: no responders on health",
+		},
+		{
+			"no reply",
+			(&fakeNATS{username: "mon", password: "secret"}).serve,
+			"health",
+			"request: no reply on health",
+		},
+		{
+			"not NATS",
+			func(conn net.Conn) { io.WriteString(conn, "220 smtp.example.com ESMTP\r\n") },
+			"",
+			`unexpected greeting "220 smtp.example.com ESMTP"`,
+		},
+		{
+			"invalid INFO",
+			func(conn net.Conn) { io.WriteString(conn, "INFO {\r\n") },
+			"",
+			"invalid INFO: ",
+		},
+	}
+	for _, tt := range tests {
+		r := &result{}
+		checkNATS(natsService(t, tt.handle, tt.subject), nil, r)
+		if r.State != stateDown || !strings.HasPrefix(r.Message, tt.want) {
+			t.Errorf("%s: result = %q %q, want down %q", tt.name, r.State, r.Message, tt.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"time"
+)
+
+// notifier represents a named notification target from the
+// configuration file.
+type notifier struct {
+	// Type is one of "osascript", for MacOS desktop notifications,
+	// or "webhook", which POSTs a JSON payload to URL.
+	Type    string            `json:"type"`
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+func (n *notifier) validate() error {
+	switch n.Type {
+	case "osascript":
+	case "webhook":
+		if n.URL == "" {
+			return fmt.Errorf("webhook notifier requires a url")
+		}
+	default:
+		return fmt.Errorf("unknown notifier type %q", n.Type)
+	}
+	return nil
+}
+
+// notification is the content delivered to a notifier for a group
+// of alerts. It is also the payload sent by webhook notifiers.
+type notification struct {
+	Title   string            `json:"title"`
+	Message string            `json:"message"`
+	Group   map[string]string `json:"group,omitempty"`
+	Alerts  []*alert          `json:"alerts"`
+}
+
+// send delivers the notification via the notifier.
+func (n *notifier) send(nt *notification) error {
+	switch n.Type {
+	case "osascript":
+		// The message and title are passed as arguments, rather than
+		// in the script, as they may contain quotes or text from
+		// outside mon.
+		return exec.Command("osascript",
+			"-e", "on run argv",
+			"-e", "display notification (item 1 of argv) with title (item 2 of argv)",
+			"-e", "end run",
+			"--", nt.Message, nt.Title).Run()
+	case "webhook":
+		b, err := json.Marshal(nt)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest(http.MethodPost, n.URL, bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range n.Headers {
+			req.Header.Set(k, v)
+		}
+		client := http.Client{
+			Timeout: 10 * time.Second,
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("webhook returned %s", resp.Status)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown notifier type %q", n.Type)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// preset knows how to interpret the health endpoint of a well-known
+// service.
+type preset struct {
+	// Path is the endpoint's path, used if the service's URL has
+	// none.
+	Path string
+	// interpret returns the state of the service, and a message
+	// explaining it, from the endpoint's response.
+	interpret func(status int, body string) (string, string)
+}
+
+// presets maps the names of presets to their definitions.
+var presets = map[string]*preset{
+	"elasticsearch":     {Path: "/_cluster/health", interpret: elasticsearchHealth},
+	"vault":             {Path: "/v1/sys/health", interpret: vaultHealth},
+	"consul":            {Path: "/v1/status/leader", interpret: consulHealth},
+	"etcd":              {Path: "/health", interpret: etcdHealth},
+	"kubernetes":        {Path: "/readyz?verbose", interpret: kubernetesHealth},
+	"traefik":           {Path: "/ping", interpret: traefikHealth},
+	"homeassistant":     {Path: "/api/", interpret: homeAssistantHealth},
+	"postgres-exporter": {Path: "/metrics", interpret: postgresExporterHealth},
+}
+
+// initPreset checks the service's preset exists, and applies its
+// path to the service's URL if the URL has none.
+func (s *service) initPreset() error {
+	p := presets[s.Preset]
+	if p == nil {
+		return fmt.Errorf("unknown preset %q", s.Preset)
+	}
+	if s.PerAddress {
+		return fmt.Errorf("preset %q cannot be checked per address", s.Preset)
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return err
+	}
+	if u.Path == "" || u.Path == "/" {
+		s.URL = strings.TrimSuffix(s.URL, "/") + p.Path
+	}
+	return nil
+}
+
+// checkPreset requests the service's URL, interpreting the response
+// according to its preset.
+func checkPreset(s *service, r *result) {
+	resp, err := fetch(s, s.URL)
+	if err != nil {
+		r.Status = http.StatusServiceUnavailable
+		r.State = stateDown
+		r.Message = err.Error()
+		return
+	}
+	r.Status = resp.status
+	r.State, r.Message = presets[s.Preset].interpret(resp.status, resp.body)
+}
+
+// unexpected returns the state and message for a response an
+// endpoint is not expected to make.
+func unexpected(status int) (string, string) {
+	return stateDown, fmt.Sprintf("unexpected status %d %s", status, http.StatusText(status))
+}
+
+// elasticsearchHealth interprets the cluster health API. Yellow
+// clusters, with unassigned replica shards, are degraded, and red
+// clusters, with unassigned primary shards, are down.
+func elasticsearchHealth(status int, body string) (string, string) {
+	var h struct {
+		Status           string `json:"status"`
+		UnassignedShards int    `json:"unassigned_shards"`
+		TimedOut         bool   `json:"timed_out"`
+	}
+	// Requests waiting for a status respond 408 when they time out,
+	// with the health in the body.
+	if status != http.StatusOK && status != http.StatusRequestTimeout {
+		return unexpected(status)
+	}
+	if err := json.Unmarshal([]byte(body), &h); err != nil {
+		return stateDown, fmt.Sprintf("invalid response: %v", err)
+	}
+	msg := fmt.Sprintf("cluster status %s", h.Status)
+	if h.UnassignedShards > 0 {
+		msg += fmt.Sprintf(", %d unassigned shards", h.UnassignedShards)
+	}
+	switch h.Status {
+	case "green":
+		return stateUp, ""
+	case "yellow":
+		return stateDegraded, msg
+	}
+	return stateDown, msg
+}
+
+// vaultHealth interprets the sys/health API, which reports the state
+// of the node in its status code. Standby nodes are up, as they serve
+// requests by forwarding them to the active node.
+func vaultHealth(status int, body string) (string, string) {
+	switch status {
+	case 200:
+		return stateUp, ""
+	case 429:
+		return stateUp, "standby"
+	case 472:
+		return stateUp, "disaster recovery secondary"
+	case 473:
+		return stateUp, "performance standby"
+	case 474:
+		return stateDegraded, "standby, unable to reach active node"
+	case 501:
+		return stateDown, "not initialized"
+	case 503:
+		return stateDown, "sealed"
+	}
+	return unexpected(status)
+}
+
+// consulHealth interprets the status/leader API. The cluster is down
+// without a leader.
+func consulHealth(status int, body string) (string, string) {
+	if status != http.StatusOK {
+		return unexpected(status)
+	}
+	var leader string
+	if err := json.Unmarshal([]byte(body), &leader); err != nil {
+		return stateDown, fmt.Sprintf("invalid response: %v", err)
+	}
+	if leader == "" {
+		return stateDown, "no cluster leader"
+	}
+	return stateUp, ""
+}
+
+// etcdHealth interprets the health endpoint.
+func etcdHealth(status int, body string) (string, string) {
+	var h struct {
+		Health string `json:"health"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(body), &h); err != nil {
+		if status != http.StatusOK {
+			return unexpected(status)
+		}
+		return stateDown, fmt.Sprintf("invalid response: %v", err)
+	}
+	if h.Health != "true" {
+		msg := "unhealthy"
+		if h.Reason != "" {
+			msg += ": " + h.Reason
+		}
+		return stateDown, msg
+	}
+	return stateUp, ""
+}
+
+// kubernetesHealth interprets the verbose output of the API server's
+// readyz endpoint, which lists each check as "[+]name ok" or
+// "[-]name failed: reason".
+func kubernetesHealth(status int, body string) (string, string) {
+	var failed []string
+	for _, line := range strings.Split(body, "\n") {
+		if name, ok := strings.CutPrefix(line, "[-]"); ok {
+			name, _, _ = strings.Cut(name, " ")
+			failed = append(failed, name)
+		}
+	}
+	switch {
+	case len(failed) > 0:
+		return stateDown, "failed checks: " + strings.Join(failed, ", ")
+	case status != http.StatusOK:
+		return unexpected(status)
+	}
+	return stateUp, ""
+}
+
+// traefikHealth interprets the ping endpoint, which responds 503
+// while Traefik is shutting down.
+func traefikHealth(status int, body string) (string, string) {
+	switch {
+	case status == http.StatusServiceUnavailable:
+		return stateDown, "shutting down"
+	case status != http.StatusOK:
+		return unexpected(status)
+	case strings.TrimSpace(body) != "OK":
+		return stateDown, fmt.Sprintf("unexpected response %q", truncate(body, 32))
+	}
+	return stateUp, ""
+}
+
+// homeAssistantHealth interprets the API's root endpoint, which
+// requires a long-lived access token in an Authorization header.
+func homeAssistantHealth(status int, body string) (string, string) {
+	switch status {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return stateDown, "unauthorized; check the access token"
+	default:
+		return unexpected(status)
+	}
+	var h struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal([]byte(body), &h); err != nil || h.Message != "API running." {
+		return stateDown, fmt.Sprintf("unexpected response %q", truncate(body, 32))
+	}
+	return stateUp, ""
+}
+
+// postgresExporterHealth interprets the pg_up metric of the Postgres
+// exporter. If the exporter monitors several servers, the service is
+// degraded unless all or none of them are up.
+func postgresExporterHealth(status int, body string) (string, string) {
+	if status != http.StatusOK {
+		return unexpected(status)
+	}
+	values := promValues(body, "pg_up")
+	if len(values) == 0 {
+		return stateDown, "no pg_up metric"
+	}
+	var down []string
+	for series, v := range values {
+		if v != 1 {
+			down = append(down, series)
+		}
+	}
+	sort.Strings(down)
+	switch {
+	case len(down) == 0:
+		return stateUp, ""
+	case len(values) == 1:
+		return stateDown, "postgres is down"
+	case len(down) == len(values):
+		return stateDown, "all postgres servers are down"
+	}
+	return stateDegraded, "postgres down: " + strings.Join(down, ", ")
+}
+
+// promValues returns the values of the named metric in a Prometheus
+// text exposition, keyed by series.
+func promValues(body, name string) map[string]float64 {
+	values := make(map[string]float64)
+	for _, line := range strings.Split(body, "\n") {
+		rest, ok := strings.CutPrefix(line, name)
+		if !ok || rest == "" || rest[0] != ' ' && rest[0] != '{' {
+			continue
+		}
+		series := name
+		if rest[0] == '{' {
+			end := strings.LastIndexByte(rest, '}')
+			if end < 0 {
+				continue
+			}
+			series, rest = name+rest[:end+1], rest[end+1:]
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		if v, err := strconv.ParseFloat(fields[0], 64); err == nil {
+			values[series] = v
+		}
+	}
+	return values
+}
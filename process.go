@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// processCheck configures a check of the processes running on the
+// host mon is running on. Processes must satisfy every criterion
+// given to be counted.
+type processCheck struct {
+	// Name matches the process name or the base name of its
+	// executable exactly.
+	Name string `json:"name,omitempty"`
+	// Cmdline is a regular expression matched against the process's
+	// command line, with arguments separated by spaces.
+	Cmdline string `json:"cmdline,omitempty"`
+	// Pidfile is the path of a file holding the process's PID.
+	Pidfile string `json:"pidfile,omitempty"`
+	// Port is a TCP port the process must be listening on.
+	Port int `json:"port,omitempty"`
+
+	// Min and Max are the allowed numbers of matching processes. Min
+	// defaults to 1, and a zero Max is unlimited.
+	Min *int `json:"min,omitempty"`
+	Max int  `json:"max,omitempty"`
+	// MaxRSS is the resident set size in megabytes above which a
+	// process is degraded.
+	MaxRSS float64 `json:"max_rss_mb,omitempty"`
+
+	re *regexp.Regexp
+}
+
+func (p *processCheck) init() error {
+	if p.Name == "" && p.Cmdline == "" && p.Pidfile == "" && p.Port == 0 {
+		return fmt.Errorf("process check requires one of name, cmdline, pidfile or port")
+	}
+	if p.Min == nil {
+		min := 1
+		p.Min = &min
+	}
+	if p.Cmdline != "" {
+		re, err := regexp.Compile(p.Cmdline)
+		if err != nil {
+			return err
+		}
+		p.re = re
+	}
+	return nil
+}
+
+// processInfo describes a matching process. It is used for output.
+type processInfo struct {
+	PID     int     `json:"pid"`
+	Name    string  `json:"name"`
+	Cmdline string  `json:"cmdline"`
+	RSS     float64 `json:"rss_mb"`
+}
+
+// checkProcess counts the processes matching the check, and checks
+// their memory use.
+func checkProcess(s *service, _ *serviceState, r *result) {
+	p := s.Process
+	procs, err := findProcesses(p)
+	if err != nil {
+		r.State = stateDown
+		r.Message = err.Error()
+		return
+	}
+	r.Processes = procs
+
+	switch {
+	case len(procs) < *p.Min:
+		r.State = stateDown
+		r.Message = fmt.Sprintf("%d processes running, expected at least %d", len(procs), *p.Min)
+		return
+	case p.Max > 0 && len(procs) > p.Max:
+		r.State = stateDown
+		r.Message = fmt.Sprintf("%d processes running, expected at most %d", len(procs), p.Max)
+		return
+	}
+	r.State = stateUp
+	var large []string
+	for _, proc := range procs {
+		if p.MaxRSS > 0 && proc.RSS > p.MaxRSS {
+			large = append(large, fmt.Sprintf("pid %d using %.0fMB", proc.PID, proc.RSS))
+		}
+	}
+	if len(large) > 0 {
+		r.State = stateDegraded
+		r.Message = strings.Join(large, "; ")
+	}
+}
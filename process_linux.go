@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// findProcesses returns the processes matching p, read from /proc.
+// Matching processes by port requires permission to read their file
+// descriptors.
+func findProcesses(p *processCheck) ([]*processInfo, error) {
+	var pids []int
+	if p.Pidfile != "" {
+		data, err := os.ReadFile(p.Pidfile)
+		if err != nil {
+			return nil, err
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pidfile %s: %w", p.Pidfile, err)
+		}
+		pids = []int{pid}
+	} else {
+		entries, err := os.ReadDir("/proc")
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if pid, err := strconv.Atoi(e.Name()); err == nil {
+				pids = append(pids, pid)
+			}
+		}
+	}
+
+	var sockets map[string]bool
+	if p.Port != 0 {
+		var err error
+		if sockets, err = listeningSockets(p.Port); err != nil {
+			return nil, err
+		}
+	}
+
+	var procs []*processInfo
+	for _, pid := range pids {
+		if pid == os.Getpid() {
+			continue
+		}
+		dir := filepath.Join("/proc", strconv.Itoa(pid))
+		comm, err := os.ReadFile(filepath.Join(dir, "comm"))
+		if err != nil {
+			// The process has exited.
+			continue
+		}
+		cmdline, _ := os.ReadFile(filepath.Join(dir, "cmdline"))
+		args := strings.Split(string(bytes.TrimRight(cmdline, "\x00")), "\x00")
+		proc := &processInfo{
+			PID:     pid,
+			Name:    strings.TrimSpace(string(comm)),
+			Cmdline: strings.Join(args, " "),
+		}
+		if p.Name != "" && proc.Name != p.Name && filepath.Base(args[0]) != p.Name {
+			continue
+		}
+		if p.re != nil && !p.re.MatchString(proc.Cmdline) {
+			continue
+		}
+		if sockets != nil && !hasSocket(dir, sockets) {
+			continue
+		}
+		if statm, err := readFields(filepath.Join(dir, "statm")); err == nil {
+			pages, _ := strconv.ParseFloat(statm[1], 64)
+			proc.RSS = pages * float64(os.Getpagesize()) / (1 << 20)
+		}
+		procs = append(procs, proc)
+	}
+	return procs, nil
+}
+
+// listeningSockets returns the inodes of TCP sockets listening on
+// port.
+func listeningSockets(port int) (map[string]bool, error) {
+	inodes := make(map[string]bool)
+	for _, file := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		f, err := os.Open(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			// Fields are the entry number, local address, remote
+			// address, state and so on, with the inode tenth.
+			fields := strings.Fields(s.Text())
+			if len(fields) < 10 || fields[3] != "0A" {
+				continue
+			}
+			_, hexPort, _ := strings.Cut(fields[1], ":")
+			if n, err := strconv.ParseInt(hexPort, 16, 32); err == nil && int(n) == port {
+				inodes["socket:["+fields[9]+"]"] = true
+			}
+		}
+		f.Close()
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return inodes, nil
+}
+
+// hasSocket reports whether the process with the /proc directory dir
+// has any of the given sockets open.
+func hasSocket(dir string, sockets map[string]bool) bool {
+	fds, err := os.ReadDir(filepath.Join(dir, "fd"))
+	if err != nil {
+		return false
+	}
+	for _, fd := range fds {
+		link, err := os.Readlink(filepath.Join(dir, "fd", fd.Name()))
+		if err == nil && sockets[link] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// findProcesses is only supported on Linux.
+func findProcesses(p *processCheck) ([]*processInfo, error) {
+	return nil, errors.New("process checks are only supported on Linux")
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// remediationTimeout is the time allowed for each remediation
+// action to complete.
+const remediationTimeout = 30 * time.Second
+
+// onDown configures actions taken to remediate a service once it has
+// been down for a number of consecutive checks.
+type onDown struct {
+	// After is the number of consecutive down checks needed before
+	// actions are taken (default 1).
+	After   int       `json:"after,omitempty"`
+	Actions []*action `json:"actions"`
+	// MaxAttempts limits the number of remediation attempts within
+	// Window (default 3 per hour).
+	MaxAttempts int       `json:"max_attempts,omitempty"`
+	Window      *duration `json:"window,omitempty"`
+	// Cooldown is the minimum time between attempts.
+	Cooldown *duration `json:"cooldown,omitempty"`
+	// DryRun records the attempts that would be made without
+	// running any actions.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// action is a remediation action, either a command to run or a URL
+// to POST to.
+type action struct {
+	Command []string `json:"command,omitempty"`
+	Webhook string   `json:"webhook,omitempty"`
+}
+
+func (o *onDown) init() error {
+	if o.After == 0 {
+		o.After = 1
+	}
+	if o.MaxAttempts == 0 {
+		o.MaxAttempts = 3
+	}
+	if o.Window == nil {
+		w := duration(time.Hour)
+		o.Window = &w
+	}
+	if len(o.Actions) == 0 {
+		return fmt.Errorf("on_down requires at least one action")
+	}
+	for _, a := range o.Actions {
+		if (len(a.Command) == 0) == (a.Webhook == "") {
+			return fmt.Errorf("on_down actions require one of command or webhook")
+		}
+	}
+	return nil
+}
+
+func (a *action) String() string {
+	if a.Webhook != "" {
+		return "POST " + a.Webhook
+	}
+	return strings.Join(a.Command, " ")
+}
+
+// remediation records a remediation attempt. It is also used for
+// output.
+type remediation struct {
+	Time    time.Time       `json:"time"`
+	Attempt int             `json:"attempt"`
+	DryRun  bool            `json:"dry_run,omitempty"`
+	Actions []*actionResult `json:"actions"`
+}
+
+// actionResult records the outcome of a remediation action.
+type actionResult struct {
+	Action string `json:"action"`
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (rm *remediation) String() string {
+	s := make([]string, len(rm.Actions))
+	for i, a := range rm.Actions {
+		switch {
+		case rm.DryRun:
+			s[i] = fmt.Sprintf("would run %q", a.Action)
+		case a.Error != "":
+			s[i] = fmt.Sprintf("ran %q: %s", a.Action, a.Error)
+		default:
+			s[i] = fmt.Sprintf("ran %q", a.Action)
+		}
+	}
+	return fmt.Sprintf("remediation attempt %d %s", rm.Attempt, strings.Join(s, ", "))
+}
+
+// remediate runs the on_down actions of services that have been down
+// for long enough, within their attempt limits. It is called after
+// countFailures.
+func remediate(results []*result, st *state, now time.Time) {
+	for _, r := range results {
+		ss := st.service(r.Name)
+		o := r.service.OnDown
+		if r.State != stateDown || o == nil || ss.Failures < o.After {
+			continue
+		}
+		// Forget attempts made outside the window.
+		var recent []time.Time
+		for _, t := range ss.Remediations {
+			if now.Sub(t) < time.Duration(*o.Window) {
+				recent = append(recent, t)
+			}
+		}
+		ss.Remediations = recent
+		if len(recent) >= o.MaxAttempts {
+			continue
+		}
+		if o.Cooldown != nil && len(recent) > 0 &&
+			now.Sub(recent[len(recent)-1]) < time.Duration(*o.Cooldown) {
+			continue
+		}
+
+		rm := &remediation{
+			Time:    now,
+			Attempt: len(recent) + 1,
+			DryRun:  o.DryRun,
+		}
+		for _, a := range o.Actions {
+			ar := &actionResult{Action: a.String()}
+			if !o.DryRun {
+				out, err := a.run(r)
+				ar.Output = out
+				if err != nil {
+					ar.Error = err.Error()
+				}
+			}
+			rm.Actions = append(rm.Actions, ar)
+		}
+		ss.Remediations = append(ss.Remediations, now)
+		r.Remediation = rm
+		logger.Info("remediation attempted",
+			"service", r.Name,
+			"remediation", rm.String())
+	}
+}
+
+// run performs the action for the failing service described by r,
+// returning any output.
+func (a *action) run(r *result) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remediationTimeout)
+	defer cancel()
+	if a.Webhook == "" {
+		out, err := exec.CommandContext(ctx, a.Command[0], a.Command[1:]...).CombinedOutput()
+		return truncate(strings.TrimSpace(string(out)), 500), err
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.Webhook, bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return "", nil
+}
+
+// truncate shortens s to at most n bytes.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// alert is a condition raised for a service that may result in
+// notifications being sent.
+type alert struct {
+	Labels  map[string]string `json:"labels"`
+	Tags    []string          `json:"tags,omitempty"`
+	Summary string            `json:"summary"`
+
+	// remediated is set if a remediation of the service was just
+	// attempted, which is notified regardless of repeat_interval.
+	remediated bool
+}
+
+// newAlert returns the named alert for the service s, which is in
+// the given state. Alerts raised because a service is failing are
+// named "state".
+func newAlert(s *service, name, state, summary string) *alert {
+	a := &alert{
+		Labels: map[string]string{
+			"alert":   name,
+			"service": s.Name,
+			"state":   state,
+		},
+		Tags:    s.Tags,
+		Summary: summary,
+	}
+	if s.Owner != "" {
+		a.Labels["owner"] = s.Owner
+	}
+	if s.Severity != "" {
+		a.Labels["severity"] = s.Severity
+	}
+	return a
+}
+
+// values returns the alert's values for a label. The "tag" label
+// has one value per tag.
+func (a *alert) values(label string) []string {
+	if label == "tag" {
+		return a.Tags
+	}
+	return []string{a.Labels[label]}
+}
+
+// fingerprint returns a string uniquely identifying the alert.
+func (a *alert) fingerprint() string {
+	keys := make([]string, 0, len(a.Labels))
+	for k := range a.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s=%q,", k, a.Labels[k])
+	}
+	return b.String()
+}
+
+// route is a node in the notification routing tree. Alerts enter at
+// the root route and descend into the first child route they match,
+// or into every matching child route whose continue flag is set, in
+// the manner of Prometheus Alertmanager. Unset notifiers, grouping
+// and timing settings are inherited from the parent route.
+type route struct {
+	// Match requires the named labels to equal the given values.
+	Match map[string]string `json:"match,omitempty"`
+	// MatchRE requires the named labels to match the given regular
+	// expressions.
+	MatchRE map[string]string `json:"match_re,omitempty"`
+
+	Notifiers      []string  `json:"notifiers"`
+	Continue       bool      `json:"continue,omitempty"`
+	GroupBy        []string  `json:"group_by"`
+	GroupWait      *duration `json:"group_wait,omitempty"`
+	RepeatInterval *duration `json:"repeat_interval,omitempty"`
+	Routes         []*route  `json:"routes,omitempty"`
+
+	id string
+	re map[string]*regexp.Regexp
+}
+
+// init prepares the route and its children for matching, applying
+// any settings inherited from parent.
+func (r *route) init(parent *route, id string, notifiers map[string]*notifier) error {
+	r.id = id
+	if parent != nil {
+		if r.Notifiers == nil {
+			r.Notifiers = parent.Notifiers
+		}
+		if r.GroupBy == nil {
+			r.GroupBy = parent.GroupBy
+		}
+		if r.GroupWait == nil {
+			r.GroupWait = parent.GroupWait
+		}
+		if r.RepeatInterval == nil {
+			r.RepeatInterval = parent.RepeatInterval
+		}
+	}
+	for _, n := range r.Notifiers {
+		if notifiers[n] == nil {
+			return fmt.Errorf("route %s: unknown notifier %q", id, n)
+		}
+	}
+	r.re = make(map[string]*regexp.Regexp)
+	for label, expr := range r.MatchRE {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return fmt.Errorf("route %s: %w", id, err)
+		}
+		r.re[label] = re
+	}
+	for i, child := range r.Routes {
+		if err := child.init(r, fmt.Sprintf("%s.%d", id, i), notifiers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches reports whether the alert satisfies the route's matchers.
+func (r *route) matches(a *alert) bool {
+	for label, want := range r.Match {
+		if !anyValue(a.values(label), func(v string) bool { return v == want }) {
+			return false
+		}
+	}
+	for label, re := range r.re {
+		if !anyValue(a.values(label), re.MatchString) {
+			return false
+		}
+	}
+	return true
+}
+
+func anyValue(values []string, f func(string) bool) bool {
+	for _, v := range values {
+		if f(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// route returns the routes that handle the alert.
+func (r *route) route(a *alert) []*route {
+	if !r.matches(a) {
+		return nil
+	}
+	var routes []*route
+	for _, child := range r.Routes {
+		matched := child.route(a)
+		routes = append(routes, matched...)
+		if len(matched) > 0 && !child.Continue {
+			break
+		}
+	}
+	if len(routes) == 0 {
+		routes = []*route{r}
+	}
+	return routes
+}
+
+// alertGroup is a set of alerts handled by the same route, with the
+// same values for the route's group_by labels.
+type alertGroup struct {
+	key    string
+	route  *route
+	labels map[string]string
+	alerts []*alert
+}
+
+// groupAlerts routes alerts through the tree rooted at root, and
+// groups them by route and group_by labels.
+func groupAlerts(root *route, alerts []*alert) []*alertGroup {
+	var groups []*alertGroup
+	byKey := make(map[string]*alertGroup)
+	for _, a := range alerts {
+		for _, r := range root.route(a) {
+			labels := make(map[string]string)
+			key := r.id
+			for _, l := range r.GroupBy {
+				labels[l] = strings.Join(a.values(l), ",")
+				key += fmt.Sprintf(",%s=%q", l, labels[l])
+			}
+			g, ok := byKey[key]
+			if !ok {
+				g = &alertGroup{key: key, route: r, labels: labels}
+				byKey[key] = g
+				groups = append(groups, g)
+			}
+			g.alerts = append(g.alerts, a)
+		}
+	}
+	return groups
+}
+
+// notification returns the notification to send for the group.
+func (g *alertGroup) notification() *notification {
+	n := &notification{
+		Group:  g.labels,
+		Alerts: g.alerts,
+	}
+	if len(g.alerts) == 1 {
+		n.Title = g.alerts[0].Labels["service"]
+		n.Message = g.alerts[0].Summary
+		return n
+	}
+	n.Title = fmt.Sprintf("%d services", len(g.alerts))
+	msgs := make([]string, len(g.alerts))
+	for i, a := range g.alerts {
+		msgs[i] = fmt.Sprintf("%s: %s", a.Labels["service"], a.Summary)
+	}
+	n.Message = strings.Join(msgs, "; ")
+	return n
+}
+
+// groupState records the notification history of an alert group
+// across runs.
+type groupState struct {
+	FirstSeen    time.Time `json:"first_seen"`
+	LastNotified time.Time `json:"last_notified,omitempty"`
+	Alerts       []string  `json:"alerts,omitempty"`
+}
+
+// dispatch routes and groups alerts, sending notifications for
+// groups that are due. Groups are held back until their group_wait
+// has elapsed, and are not re-sent until their repeat_interval has
+// elapsed, unless the alerts in the group change or a remediation
+// has been attempted.
+func dispatch(cfg *config, st *state, alerts []*alert, now time.Time) {
+	seen := make(map[string]bool)
+	for _, g := range groupAlerts(cfg.Route, alerts) {
+		seen[g.key] = true
+		gs := st.Groups[g.key]
+		if gs == nil {
+			gs = &groupState{FirstSeen: now}
+			st.Groups[g.key] = gs
+		}
+		if g.route.GroupWait != nil && now.Sub(gs.FirstSeen) < time.Duration(*g.route.GroupWait) {
+			continue
+		}
+
+		fps := make([]string, len(g.alerts))
+		remediated := false
+		for i, a := range g.alerts {
+			fps[i] = a.fingerprint()
+			remediated = remediated || a.remediated
+		}
+		sort.Strings(fps)
+		if !gs.LastNotified.IsZero() && !remediated &&
+			strings.Join(fps, "\n") == strings.Join(gs.Alerts, "\n") &&
+			g.route.RepeatInterval != nil &&
+			now.Sub(gs.LastNotified) < time.Duration(*g.route.RepeatInterval) {
+			continue
+		}
+
+		n := g.notification()
+		for _, name := range g.route.Notifiers {
+			if err := cfg.Notifiers[name].send(n); err != nil {
+				logger.Error("unable to send notification",
+					"notifier", name,
+					"error", err)
+			}
+		}
+		gs.LastNotified = now
+		gs.Alerts = fps
+	}
+	// Groups with no alerts have resolved.
+	for key := range st.Groups {
+		if !seen[key] {
+			delete(st.Groups, key)
+		}
+	}
+}
+
+// routesCommand implements the "routes" command.
+//
+//	mon routes test <service> [label=value...]
+//
+// prints the notifiers that would be sent an alert for the service.
+// The alert is a "state" alert for the service being down unless
+// overridden by label arguments.
+func routesCommand(cfg *config, args []string) error {
+	if len(args) < 2 || args[0] != "test" {
+		return fmt.Errorf("usage: mon routes test <service> [label=value...]")
+	}
+	var svc *service
+	for _, s := range cfg.Services {
+		if s.Name == args[1] {
+			svc = s
+		}
+	}
+	if svc == nil {
+		return fmt.Errorf("unknown service %q", args[1])
+	}
+	a := newAlert(svc, "state", stateDown, "")
+	for _, arg := range args[2:] {
+		k, v, ok := strings.Cut(arg, "=")
+		if !ok {
+			return fmt.Errorf("invalid label %q; expected label=value", arg)
+		}
+		if k == "tag" {
+			a.Tags = append(a.Tags, v)
+			continue
+		}
+		a.Labels[k] = v
+	}
+
+	seen := make(map[string]bool)
+	for _, r := range cfg.Route.route(a) {
+		for _, n := range r.Notifiers {
+			if !seen[n] {
+				seen[n] = true
+				fmt.Println(n)
+			}
+		}
+	}
+	return nil
+}
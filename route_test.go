@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNotifiers records the notifications sent to webhook notifiers
+// named "a", "b" and "c".
+type fakeNotifiers struct {
+	mu   sync.Mutex
+	sent []string
+	url  string
+}
+
+func newFakeNotifiers(t *testing.T) *fakeNotifiers {
+	t.Helper()
+	f := &fakeNotifiers{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		f.sent = append(f.sent, fmt.Sprintf("%s: %s: %s", strings.TrimPrefix(r.URL.Path, "/"), n.Title, n.Message))
+		f.mu.Unlock()
+	}))
+	t.Cleanup(ts.Close)
+	f.url = ts.URL
+	return f
+}
+
+// take returns the notifications sent since it was last called.
+func (f *fakeNotifiers) take() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sent := f.sent
+	f.sent = nil
+	return sent
+}
+
+// config returns a configuration with the given route and services,
+// sending notifications to f.
+func (f *fakeNotifiers) config(t *testing.T, route string, services ...string) *config {
+	t.Helper()
+	var notifiers []string
+	for _, name := range []string{"a", "b", "c"} {
+		notifiers = append(notifiers, fmt.Sprintf("%q: {\"type\": \"webhook\", \"url\": %q}", name, f.url+"/"+name))
+	}
+	data := fmt.Sprintf(`{"notifiers": {%s}, "route": %s, "services": [%s]}`,
+		strings.Join(notifiers, ", "), route, strings.Join(services, ", "))
+	cfg, err := parseConfig([]byte(data), "")
+	if err != nil {
+		t.Fatalf("parsing %s: %v", data, err)
+	}
+	return cfg
+}
+
+// service returns the named service from cfg.
+func (cfg *config) service(name string) *service {
+	for _, s := range cfg.Services {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestDispatchRemediation(t *testing.T) {
+	f := newFakeNotifiers(t)
+	cfg := f.config(t, `{"notifiers": ["a"], "repeat_interval": "4h"}`,
+		`{"name": "api", "url": "http://api.invalid/"}`)
+	st := &state{Groups: make(map[string]*groupState)}
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	down := newAlert(cfg.service("api"), "state", stateDown, "Service Unavailable")
+	dispatch(cfg, st, []*alert{down}, now)
+	dispatch(cfg, st, []*alert{down}, now.Add(time.Minute))
+	if got := f.take(); len(got) != 1 {
+		t.Fatalf("sent %q, want one notification", got)
+	}
+
+	// A remediation attempt is notified within the repeat interval.
+	remediated := newAlert(cfg.service("api"), "state", stateDown, "Service Unavailable; remediation attempt 1 ran \"restart\"")
+	remediated.remediated = true
+	dispatch(cfg, st, []*alert{remediated}, now.Add(2*time.Minute))
+	want := `a: api: Service Unavailable; remediation attempt 1 ran "restart"`
+	if got := f.take(); len(got) != 1 || got[0] != want {
+		t.Errorf("sent %q, want %q", got, want)
+	}
+	dispatch(cfg, st, []*alert{down}, now.Add(3*time.Minute))
+	if got := f.take(); len(got) != 0 {
+		t.Errorf("sent %q after remediation, want nothing until the repeat interval", got)
+	}
+}
+
+// routeTree is a routing tree exercising matching, continue and
+// inheritance.
+const routeTree = `{
+	"notifiers": ["a"],
+	"group_by": ["service"],
+	"group_wait": "1m",
+	"repeat_interval": "1h",
+	"routes": [
+		{"match": {"severity": "critical"}, "notifiers": ["b"], "continue": true},
+		{"match_re": {"service": "db|cache"}, "notifiers": ["c"], "group_by": ["owner"]},
+		{"match": {"tag": "web"}, "routes": [
+			{"match": {"owner": "team-a"}, "repeat_interval": "5m"}
+		]}
+	]
+}`
+
+var routeServices = []string{
+	`{"name": "api", "url": "http://api.invalid/", "owner": "team-a", "severity": "critical", "tags": ["web"]}`,
+	`{"name": "db", "url": "http://db.invalid/", "owner": "team-b"}`,
+	`{"name": "cache", "url": "http://cache.invalid/", "owner": "team-b"}`,
+	`{"name": "database", "url": "http://database.invalid/", "owner": "team-b"}`,
+	`{"name": "www", "url": "http://www.invalid/", "owner": "team-b", "tags": ["public", "web"]}`,
+	`{"name": "primary", "url": "http://primary.invalid/", "owner": "team-b", "severity": "critical"}`,
+}
+
+func TestRoute(t *testing.T) {
+	cfg := newFakeNotifiers(t).config(t, routeTree, routeServices...)
+	tests := []struct {
+		service string
+		labels  map[string]string
+		want    []string
+	}{
+		// Matching a route with continue set carries on to the
+		// next matching route.
+		{"api", nil, []string{"0.0", "0.2.0"}},
+		{"api", map[string]string{"severity": "warning"}, []string{"0.2.0"}},
+		{"api", map[string]string{"owner": "team-b"}, []string{"0.0", "0.2"}},
+		// Otherwise the first matching route handles the alert.
+		{"db", nil, []string{"0.1"}},
+		{"cache", nil, []string{"0.1"}},
+		{"www", nil, []string{"0.2"}},
+		{"primary", nil, []string{"0.0"}},
+		{"primary", map[string]string{"service": "db"}, []string{"0.0", "0.1"}},
+		// Regular expressions must match the whole value, and
+		// alerts matching no child are handled by the root.
+		{"database", nil, []string{"0"}},
+		{"database", map[string]string{"service": "xdb"}, []string{"0"}},
+	}
+	for _, tt := range tests {
+		a := newAlert(cfg.service(tt.service), "state", stateDown, "")
+		for k, v := range tt.labels {
+			a.Labels[k] = v
+		}
+		var got []string
+		for _, r := range cfg.Route.route(a) {
+			got = append(got, r.id)
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s %v routed to %v, want %v", tt.service, tt.labels, got, tt.want)
+		}
+	}
+}
+
+func TestRouteInheritance(t *testing.T) {
+	cfg := newFakeNotifiers(t).config(t, routeTree, routeServices...)
+	routes := map[string]*route{"0": cfg.Route}
+	var walk func(r *route)
+	walk = func(r *route) {
+		for _, child := range r.Routes {
+			routes[child.id] = child
+			walk(child)
+		}
+	}
+	walk(cfg.Route)
+
+	tests := []struct {
+		id             string
+		notifiers      string
+		groupBy        string
+		groupWait      time.Duration
+		repeatInterval time.Duration
+	}{
+		{"0", "a", "service", time.Minute, time.Hour},
+		{"0.0", "b", "service", time.Minute, time.Hour},
+		{"0.1", "c", "owner", time.Minute, time.Hour},
+		{"0.2", "a", "service", time.Minute, time.Hour},
+		{"0.2.0", "a", "service", time.Minute, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		r := routes[tt.id]
+		if r == nil {
+			t.Errorf("no route %s", tt.id)
+			continue
+		}
+		if got := strings.Join(r.Notifiers, ","); got != tt.notifiers {
+			t.Errorf("route %s notifiers = %s, want %s", tt.id, got, tt.notifiers)
+		}
+		if got := strings.Join(r.GroupBy, ","); got != tt.groupBy {
+			t.Errorf("route %s group_by = %s, want %s", tt.id, got, tt.groupBy)
+		}
+		if got := time.Duration(*r.GroupWait); got != tt.groupWait {
+			t.Errorf("route %s group_wait = %s, want %s", tt.id, got, tt.groupWait)
+		}
+		if got := time.Duration(*r.RepeatInterval); got != tt.repeatInterval {
+			t.Errorf("route %s repeat_interval = %s, want %s", tt.id, got, tt.repeatInterval)
+		}
+	}
+
+	for _, route := range []string{
+		`{"notifiers": ["d"]}`,
+		`{"routes": [{"notifiers": ["a", "d"]}]}`,
+		`{"routes": [{"match_re": {"service": "("}}]}`,
+	} {
+		data := fmt.Sprintf(`{"notifiers": {"a": {"type": "osascript"}}, "route": %s, "services": []}`, route)
+		if _, err := parseConfig([]byte(data), ""); err == nil {
+			t.Errorf("route %s parsed, want error", route)
+		}
+	}
+}
+
+func TestGroupAlerts(t *testing.T) {
+	cfg := newFakeNotifiers(t).config(t, routeTree, routeServices...)
+	var alerts []*alert
+	for _, name := range []string{"api", "db", "cache", "www"} {
+		alerts = append(alerts, newAlert(cfg.service(name), "state", stateDown, "Service Unavailable"))
+	}
+	var got []string
+	for _, g := range groupAlerts(cfg.Route, alerts) {
+		n := g.notification()
+		got = append(got, fmt.Sprintf("%s %v %s: %s", g.route.id, g.labels, n.Title, n.Message))
+	}
+	want := []string{
+		"0.0 map[service:api] api: Service Unavailable",
+		"0.2.0 map[service:api] api: Service Unavailable",
+		"0.1 map[owner:team-b] 2 services: db: Service Unavailable; cache: Service Unavailable",
+		"0.2 map[service:www] www: Service Unavailable",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("groups:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	f := newFakeNotifiers(t)
+	cfg := f.config(t, routeTree, routeServices...)
+	st := &state{Groups: make(map[string]*groupState)}
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	down := func(name string) *alert {
+		return newAlert(cfg.service(name), "state", stateDown, "Service Unavailable")
+	}
+	degraded := newAlert(cfg.service("api"), "state", stateDegraded, "slow response")
+
+	runs := []struct {
+		after  time.Duration
+		alerts []*alert
+		want   []string
+	}{
+		// Groups wait for group_wait before being notified.
+		{0, []*alert{down("api")}, nil},
+		{30 * time.Second, []*alert{down("api")}, nil},
+		{time.Minute, []*alert{down("api")}, []string{
+			"b: api: Service Unavailable",
+			"a: api: Service Unavailable",
+		}},
+		// Unchanged groups aren't notified again until their
+		// repeat_interval has elapsed.
+		{2 * time.Minute, []*alert{down("api")}, nil},
+		{6 * time.Minute, []*alert{down("api")}, []string{"a: api: Service Unavailable"}},
+		// Changed groups are notified at once.
+		{7 * time.Minute, []*alert{degraded}, []string{
+			"b: api: slow response",
+			"a: api: slow response",
+		}},
+		// Alerts joining a new group wait for it.
+		{8 * time.Minute, []*alert{degraded, down("db")}, nil},
+		{9 * time.Minute, []*alert{degraded, down("db"), down("cache")}, []string{
+			"c: 2 services: db: Service Unavailable; cache: Service Unavailable",
+		}},
+		// Resolved groups are forgotten, and wait again if their
+		// alerts recur.
+		{10 * time.Minute, nil, nil},
+		{11 * time.Minute, []*alert{down("db")}, nil},
+		{12 * time.Minute, []*alert{down("db")}, []string{"c: db: Service Unavailable"}},
+	}
+	for _, run := range runs {
+		dispatch(cfg, st, run.alerts, start.Add(run.after))
+		if got := f.take(); strings.Join(got, "\n") != strings.Join(run.want, "\n") {
+			t.Errorf("after %s: sent %q, want %q", run.after, got, run.want)
+		}
+	}
+	if len(st.Groups) != 1 {
+		t.Errorf("%d groups in state, want 1", len(st.Groups))
+	}
+}
+
+func TestRoutesCommand(t *testing.T) {
+	cfg := newFakeNotifiers(t).config(t, routeTree, routeServices...)
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"test", "api"}, "b\na\n"},
+		{[]string{"test", "api", "severity=warning"}, "a\n"},
+		{[]string{"test", "db"}, "c\n"},
+		{[]string{"test", "database", "tag=web"}, "a\n"},
+		{[]string{"test", "primary", "service=cache"}, "b\nc\n"},
+	}
+	for _, tt := range tests {
+		got, err := captureStdout(t, func() error { return routesCommand(cfg, tt.args) })
+		if err != nil {
+			t.Errorf("routes %v: %v", tt.args, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("routes %v printed %q, want %q", tt.args, got, tt.want)
+		}
+	}
+
+	for _, args := range [][]string{
+		{},
+		{"test"},
+		{"list", "api"},
+		{"test", "unknown"},
+		{"test", "api", "severity"},
+	} {
+		if _, err := captureStdout(t, func() error { return routesCommand(cfg, args) }); err == nil {
+			t.Errorf("routes %v succeeded, want error", args)
+		}
+	}
+}
+
+// captureStdout returns what f writes to standard output.
+func captureStdout(t *testing.T, f func() error) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	err = f()
+	os.Stdout = stdout
+	w.Close()
+	out, _ := io.ReadAll(r)
+	r.Close()
+	return string(out), err
+}
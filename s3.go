@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// s3MaxPages is the maximum number of pages of objects listed when
+// looking for the newest object under a prefix.
+const s3MaxPages = 100
+
+// s3Check configures a check of a bucket in S3 compatible object
+// storage, and optionally of the freshness of the objects under a
+// prefix, such as backups.
+type s3Check struct {
+	// Endpoint is the URL of the storage service, with buckets
+	// addressed by path. If empty, the AWS endpoint for the region
+	// is used, with buckets addressed by host name.
+	Endpoint string `json:"endpoint,omitempty"`
+	Bucket   string `json:"bucket"`
+	Prefix   string `json:"prefix,omitempty"`
+	// MaxAge is the age of the newest object under Prefix above
+	// which the service is down.
+	MaxAge *duration `json:"max_age,omitempty"`
+	// MinSize is the size in bytes of the newest object under
+	// Prefix below which the service is down.
+	MinSize int64 `json:"min_size,omitempty"`
+	sigv4
+}
+
+func (c *s3Check) init() error {
+	if c.Bucket == "" {
+		return fmt.Errorf("s3 check requires a bucket")
+	}
+	if err := c.sigv4.init(); err != nil {
+		return err
+	}
+	c.Service = "s3"
+	return nil
+}
+
+// objectInfo describes an object in a bucket. It is used for output.
+type objectInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// listBucketResult is a page of a ListObjectsV2 response.
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// checkS3 lists the bucket to check it is reachable. If a maximum age
+// or minimum size is configured, the newest object under the prefix
+// must satisfy them.
+func checkS3(s *service, _ *serviceState, r *result) {
+	c := s.S3
+	bucketURL := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/", c.Bucket, c.Region)
+	if c.Endpoint != "" {
+		bucketURL = strings.TrimSuffix(c.Endpoint, "/") + "/" + url.PathEscape(c.Bucket) + "/"
+	}
+	r.URL = bucketURL
+
+	fresh := c.MaxAge != nil || c.MinSize > 0
+	var newest *objectInfo
+	token := ""
+	for page := 0; page < s3MaxPages; page++ {
+		q := url.Values{"list-type": {"2"}}
+		if c.Prefix != "" {
+			q.Set("prefix", c.Prefix)
+		}
+		if !fresh {
+			q.Set("max-keys", "1")
+		}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		list, err := c.list(bucketURL + "?" + q.Encode())
+		if err != nil {
+			r.State = stateDown
+			r.Message = err.Error()
+			return
+		}
+		for _, o := range list.Contents {
+			if newest == nil || o.LastModified.After(newest.LastModified) {
+				newest = &objectInfo{Key: o.Key, Size: o.Size, LastModified: o.LastModified}
+			}
+		}
+		if !fresh || !list.IsTruncated {
+			break
+		}
+		token = list.NextContinuationToken
+	}
+
+	r.State = stateUp
+	r.Object = newest
+	if !fresh {
+		return
+	}
+	switch {
+	case newest == nil:
+		r.State = stateDown
+		r.Message = fmt.Sprintf("no objects under %q", c.Prefix)
+	case c.MaxAge != nil && time.Since(newest.LastModified) > time.Duration(*c.MaxAge):
+		r.State = stateDown
+		r.Message = fmt.Sprintf("newest object %s is %s old", newest.Key,
+			time.Since(newest.LastModified).Round(time.Minute))
+	case newest.Size < c.MinSize:
+		r.State = stateDown
+		r.Message = fmt.Sprintf("newest object %s is only %d bytes", newest.Key, newest.Size)
+	}
+}
+
+// list requests a page of a ListObjectsV2 response.
+func (c *s3Check) list(u string) (*listBucketResult, error) {
+	client := http.Client{
+		Timeout: 10 * time.Second,
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.sign(req, time.Now()); err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		if xml.NewDecoder(resp.Body).Decode(&e) == nil && e.Code != "" {
+			return nil, fmt.Errorf("listing bucket: %s: %s", e.Code, e.Message)
+		}
+		return nil, fmt.Errorf("listing bucket: %s", resp.Status)
+	}
+	list := &listBucketResult{}
+	if err := xml.NewDecoder(resp.Body).Decode(list); err != nil {
+		return nil, fmt.Errorf("listing bucket: %w", err)
+	}
+	return list, nil
+}
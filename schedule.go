@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule is a cron expression, represented in JSON as a string
+// such as "*/5 9-18 * * MON-FRI". Its five fields match the minute,
+// hour, day of the month, month and day of the week. Each is "*" or a
+// list of values or ranges of values, such as "1,15" or "9-17", each
+// optionally followed by a step, such as "*/10". Months and days of
+// the week may be given by name. The expressions @yearly, @monthly,
+// @weekly, @daily and @hourly are also accepted.
+type schedule struct {
+	expr string
+
+	minute, hour, dom, month, dow uint64
+	// anyDOM and anyDOW are set if the day of the month or week is
+	// unrestricted. If neither is, a day matching either matches.
+	anyDOM, anyDOW bool
+}
+
+// scheduleMacros maps the names of predefined schedules to their
+// expressions.
+var scheduleMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	dayNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+func (s *schedule) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &s.expr); err != nil {
+		return err
+	}
+	expr := s.expr
+	if m, ok := scheduleMacros[strings.ToLower(expr)]; ok {
+		expr = m
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return fmt.Errorf("invalid schedule %q: want 5 fields", s.expr)
+	}
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return fmt.Errorf("invalid schedule %q: minute: %w", s.expr, err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return fmt.Errorf("invalid schedule %q: hour: %w", s.expr, err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return fmt.Errorf("invalid schedule %q: day of month: %w", s.expr, err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return fmt.Errorf("invalid schedule %q: month: %w", s.expr, err)
+	}
+	// Sunday is either 0 or 7.
+	if s.dow, err = parseCronField(fields[4], 0, 7, dayNames); err != nil {
+		return fmt.Errorf("invalid schedule %q: day of week: %w", s.expr, err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.anyDOM = strings.HasPrefix(fields[2], "*")
+	s.anyDOW = strings.HasPrefix(fields[4], "*")
+	if s.next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), time.UTC).IsZero() {
+		return fmt.Errorf("invalid schedule %q: never matches", s.expr)
+	}
+	return nil
+}
+
+func (s *schedule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.expr)
+}
+
+// parseCronField returns the set of values matched by a field of a
+// cron expression as a bit set. Values may be given by their names,
+// the first of which has the value min.
+func parseCronField(field string, min, max int, names []string) (uint64, error) {
+	value := func(s string) (int, error) {
+		for i, name := range names {
+			if strings.EqualFold(s, name) {
+				return min + i, nil
+			}
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("invalid value %q", s)
+		}
+		return n, nil
+	}
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		r, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepStr)
+			}
+		}
+		lo, hi := min, max
+		if r != "*" {
+			loStr, hiStr, isRange := strings.Cut(r, "-")
+			var err error
+			if lo, err = value(loStr); err != nil {
+				return 0, err
+			}
+			switch {
+			case isRange:
+				if hi, err = value(hiStr); err != nil {
+					return 0, err
+				}
+			case !hasStep:
+				hi = lo
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range %q", r)
+			}
+		}
+		for i := lo; i <= hi; i += step {
+			bits |= 1 << i
+		}
+	}
+	return bits, nil
+}
+
+// matches reports whether t, to the minute, matches the schedule.
+func (s *schedule) matches(t time.Time) bool {
+	return s.month&(1<<t.Month()) != 0 && s.matchesDay(t) &&
+		s.hour&(1<<t.Hour()) != 0 && s.minute&(1<<t.Minute()) != 0
+}
+
+func (s *schedule) matchesDay(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<t.Weekday()) != 0
+	if s.anyDOM || s.anyDOW {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next returns the first time after t matching the schedule in loc,
+// or the zero time if none does within 28 years, after which the days
+// of the week fall on the same dates.
+func (s *schedule) next(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(28, 0, 0)
+	for t.Before(limit) {
+		// Skip whole months, days and hours that can't match,
+		// taking care to make progress across changes in the
+		// offset of loc. Hours are skipped in elapsed time, so
+		// that an hour repeated when clocks go back is matched
+		// both times.
+		var n time.Time
+		switch {
+		case s.month&(1<<t.Month()) == 0:
+			n = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.matchesDay(t):
+			n = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<t.Hour()) == 0:
+			n = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		case s.minute&(1<<t.Minute()) == 0:
+			n = t.Add(time.Minute)
+		default:
+			return t
+		}
+		if !n.After(t) {
+			n = t.Add(time.Minute)
+		}
+		t = n
+	}
+	return time.Time{}
+}
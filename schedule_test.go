@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// bits returns a bit set of the given values.
+func bits(values ...int) uint64 {
+	var b uint64
+	for _, v := range values {
+		b |= 1 << v
+	}
+	return b
+}
+
+func parseSchedule(t *testing.T, expr string) *schedule {
+	t.Helper()
+	s := &schedule{}
+	b, _ := json.Marshal(expr)
+	if err := json.Unmarshal(b, s); err != nil {
+		t.Fatalf("parsing %q: %v", expr, err)
+	}
+	return s
+}
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field    string
+		min, max int
+		names    []string
+		want     uint64
+	}{
+		{"*", 0, 5, nil, bits(0, 1, 2, 3, 4, 5)},
+		{"7", 0, 59, nil, bits(7)},
+		{"1,15", 1, 31, nil, bits(1, 15)},
+		{"9-17", 0, 23, nil, bits(9, 10, 11, 12, 13, 14, 15, 16, 17)},
+		{"*/15", 0, 59, nil, bits(0, 15, 30, 45)},
+		{"5/20", 0, 59, nil, bits(5, 25, 45)},
+		{"10-20/5", 0, 59, nil, bits(10, 15, 20)},
+		{"0-4,*/20", 0, 59, nil, bits(0, 1, 2, 3, 4, 20, 40)},
+		{"MON-FRI", 0, 7, dayNames, bits(1, 2, 3, 4, 5)},
+		{"sun,Sat", 0, 7, dayNames, bits(0, 6)},
+		{"jan,DEC", 1, 12, monthNames, bits(1, 12)},
+		{"apr-jun", 1, 12, monthNames, bits(4, 5, 6)},
+	}
+	for _, tt := range tests {
+		got, err := parseCronField(tt.field, tt.min, tt.max, tt.names)
+		if err != nil {
+			t.Errorf("parseCronField(%q): %v", tt.field, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseCronField(%q) = %b, want %b", tt.field, got, tt.want)
+		}
+	}
+
+	for _, field := range []string{"", "60", "-1", "5-1", "*/0", "*/x", "foo", "1-", "1,,2"} {
+		if _, err := parseCronField(field, 0, 59, nil); err == nil {
+			t.Errorf("parseCronField(%q) succeeded, want error", field)
+		}
+	}
+}
+
+func TestScheduleParse(t *testing.T) {
+	for _, expr := range []string{
+		"* * *",
+		"* * * * * *",
+		"0 24 * * *",
+		"0 0 0 * *",
+		"0 0 * 13 *",
+		"0 0 * * 8",
+		"@fortnightly",
+		// Never matches.
+		"0 0 31 2 *",
+		"0 0 30 feb *",
+	} {
+		b, _ := json.Marshal(expr)
+		if err := json.Unmarshal(b, &schedule{}); err == nil {
+			t.Errorf("parsing %q succeeded, want error", expr)
+		}
+	}
+}
+
+func TestScheduleShorthands(t *testing.T) {
+	for macro, expr := range map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+		"@DAILY":    "0 0 * * *",
+	} {
+		got, want := parseSchedule(t, macro), parseSchedule(t, expr)
+		got.expr, want.expr = "", ""
+		if *got != *want {
+			t.Errorf("%s = %+v, want %+v", macro, got, want)
+		}
+	}
+}
+
+func TestScheduleMatches(t *testing.T) {
+	tests := []struct {
+		expr string
+		time string
+		want bool
+	}{
+		{"*/5 9-18 * * MON-FRI", "2026-10-16T09:05:00Z", true},
+		{"*/5 9-18 * * MON-FRI", "2026-10-16T09:06:00Z", false},
+		{"*/5 9-18 * * MON-FRI", "2026-10-17T09:05:00Z", false},
+		{"*/5 9-18 * * MON-FRI", "2026-10-16T19:00:00Z", false},
+		// With both restricted, a day matching either the day of the
+		// month or of the week matches.
+		{"0 0 13 * FRI", "2026-10-13T00:00:00Z", true},
+		{"0 0 13 * FRI", "2026-10-16T00:00:00Z", true},
+		{"0 0 13 * FRI", "2026-10-14T00:00:00Z", false},
+		// With either unrestricted, both must match.
+		{"0 0 * * FRI", "2026-10-13T00:00:00Z", false},
+		{"0 0 */2 * FRI", "2026-10-16T00:00:00Z", false},
+		{"0 0 */2 * FRI", "2026-10-23T00:00:00Z", true},
+		{"0 0 13 * *", "2026-10-16T00:00:00Z", false},
+		// Sunday is 0 or 7.
+		{"0 0 * * 7", "2026-10-18T00:00:00Z", true},
+		{"0 0 * * 0", "2026-10-18T00:00:00Z", true},
+		{"0 0 * * 5-7", "2026-10-18T00:00:00Z", true},
+		{"0 0 * * 7", "2026-10-17T00:00:00Z", false},
+		{"0 0 1 jan-mar *", "2026-02-01T00:00:00Z", true},
+		{"0 0 1 jan-mar *", "2026-04-01T00:00:00Z", false},
+	}
+	for _, tt := range tests {
+		s := parseSchedule(t, tt.expr)
+		tm, err := time.Parse(time.RFC3339, tt.time)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := s.matches(tm); got != tt.want {
+			t.Errorf("%q matches %s = %v, want %v", tt.expr, tt.time, got, tt.want)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip(err)
+	}
+	tests := []struct {
+		expr string
+		loc  *time.Location
+		from string
+		want string
+	}{
+		{"*/5 9-18 * * MON-FRI", time.UTC, "2026-10-16T18:57:00Z", "2026-10-19T09:00:00Z"},
+		{"*/5 * * * *", time.UTC, "2026-10-16T10:00:00Z", "2026-10-16T10:05:00Z"},
+		{"*/5 * * * *", time.UTC, "2026-10-16T10:02:30Z", "2026-10-16T10:05:00Z"},
+		{"0 0 29 2 *", time.UTC, "2026-01-01T00:00:00Z", "2028-02-29T00:00:00Z"},
+		{"@weekly", time.UTC, "2026-10-16T00:00:00Z", "2026-10-18T00:00:00Z"},
+		{"0 9 * * *", london, "2026-07-01T00:00:00Z", "2026-07-01T08:00:00Z"},
+		{"0 9 * * *", london, "2026-12-01T00:00:00Z", "2026-12-01T09:00:00Z"},
+
+		// BST starts at 01:00 GMT on 29 March 2026, when clocks go
+		// forward to 02:00. Times in the skipped hour don't occur.
+		{"30 1 * * *", london, "2026-03-29T00:00:00Z", "2026-03-30T00:30:00Z"},
+		{"0 2 * * *", london, "2026-03-29T00:00:00Z", "2026-03-29T01:00:00Z"},
+		{"*/30 * * * *", london, "2026-03-29T00:45:00Z", "2026-03-29T01:00:00Z"},
+		{"0 9 * * *", london, "2026-03-28T12:00:00Z", "2026-03-29T08:00:00Z"},
+
+		// BST ends at 01:00 GMT on 25 October 2026, when clocks go
+		// back from 02:00 to 01:00. Times in the repeated hour occur
+		// twice.
+		{"30 1 * * *", london, "2026-10-24T12:00:00Z", "2026-10-25T00:30:00Z"},
+		{"30 1 * * *", london, "2026-10-25T00:30:00Z", "2026-10-25T01:30:00Z"},
+		{"0 2 * * *", london, "2026-10-25T00:00:00Z", "2026-10-25T02:00:00Z"},
+		{"0 9 * * *", london, "2026-10-24T12:00:00Z", "2026-10-25T09:00:00Z"},
+	}
+	for _, tt := range tests {
+		s := parseSchedule(t, tt.expr)
+		from, err := time.Parse(time.RFC3339, tt.from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := time.Parse(time.RFC3339, tt.want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := s.next(from, tt.loc); !got.Equal(want) {
+			t.Errorf("%q next after %s = %s, want %s", tt.expr, tt.from, got.UTC().Format(time.RFC3339), tt.want)
+		}
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxViolations is the maximum number of schema violations reported
+// for a response.
+const maxViolations = 20
+
+// schemaConfig is a JSON Schema that the bodies of successful
+// responses must validate against. In the services file, it is either
+// the schema itself, or the path of a file containing it, relative to
+// the directory of the services file.
+//
+// The assertion keywords of draft 2020-12 are supported, other than
+// those concerning formats, dynamic references, dependencies, content
+// and unevaluated items and properties. References must be to the
+// same document, as in "#/$defs/user".
+type schemaConfig struct {
+	File string
+
+	root     any
+	patterns map[string]*regexp.Regexp
+}
+
+func (c *schemaConfig) UnmarshalJSON(b []byte) error {
+	if b := bytes.TrimSpace(b); len(b) > 0 && b[0] == '"' {
+		return json.Unmarshal(b, &c.File)
+	}
+	return json.Unmarshal(b, &c.root)
+}
+
+func (c *schemaConfig) MarshalJSON() ([]byte, error) {
+	if c.File != "" {
+		return json.Marshal(c.File)
+	}
+	return json.Marshal(c.root)
+}
+
+// init reads and prepares the schema, resolving a relative File
+// against dir.
+func (c *schemaConfig) init(dir string) error {
+	if c.File != "" {
+		if !filepath.IsAbs(c.File) {
+			c.File = filepath.Join(dir, c.File)
+		}
+		data, err := os.ReadFile(c.File)
+		if err != nil {
+			return fmt.Errorf("schema: %w", err)
+		}
+		if err := json.Unmarshal(data, &c.root); err != nil {
+			return fmt.Errorf("schema %s: %w", c.File, err)
+		}
+	}
+	c.patterns = make(map[string]*regexp.Regexp)
+	return c.prepare(c.root)
+}
+
+// prepare compiles the regular expressions used by schema s and its
+// subschemas, and checks its references can be resolved.
+func (c *schemaConfig) prepare(s any) error {
+	switch s := s.(type) {
+	case []any:
+		for _, v := range s {
+			if err := c.prepare(v); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		for k, v := range s {
+			switch k {
+			case "enum", "const", "default", "examples":
+			case "properties", "patternProperties", "$defs", "definitions":
+				// These map names to subschemas.
+				m, _ := v.(map[string]any)
+				for name, sub := range m {
+					if k == "patternProperties" {
+						if err := c.compile(name); err != nil {
+							return err
+						}
+					}
+					if err := c.prepare(sub); err != nil {
+						return err
+					}
+				}
+			case "pattern":
+				if p, ok := v.(string); ok {
+					if err := c.compile(p); err != nil {
+						return err
+					}
+				}
+			case "$ref":
+				if ref, ok := v.(string); ok {
+					if _, err := c.resolve(ref); err != nil {
+						return err
+					}
+				}
+			default:
+				if err := c.prepare(v); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (c *schemaConfig) compile(p string) error {
+	re, err := regexp.Compile(p)
+	if err != nil {
+		return fmt.Errorf("schema: %w", err)
+	}
+	c.patterns[p] = re
+	return nil
+}
+
+// resolve returns the subschema referenced by ref.
+func (c *schemaConfig) resolve(ref string) (any, error) {
+	ptr, ok := strings.CutPrefix(ref, "#")
+	if !ok {
+		return nil, fmt.Errorf("schema: unsupported reference %q", ref)
+	}
+	s := c.root
+	if ptr == "" {
+		return s, nil
+	}
+	for _, tok := range strings.Split(strings.TrimPrefix(ptr, "/"), "/") {
+		tok = strings.NewReplacer("~1", "/", "~0", "~").Replace(tok)
+		switch t := s.(type) {
+		case map[string]any:
+			s, ok = t[tok]
+		case []any:
+			i, err := strconv.Atoi(tok)
+			ok = err == nil && i >= 0 && i < len(t)
+			if ok {
+				s = t[i]
+			}
+		default:
+			ok = false
+		}
+		if !ok {
+			return nil, fmt.Errorf("schema: unresolved reference %q", ref)
+		}
+	}
+	return s, nil
+}
+
+// violation is a way in which a document fails to validate against a
+// schema. It is used for output.
+type violation struct {
+	// Pointer is the JSON pointer to the invalid value.
+	Pointer string `json:"pointer"`
+	Message string `json:"message"`
+}
+
+// schemaError reports the violations found validating a response.
+type schemaError struct {
+	violations []*violation
+}
+
+func (e *schemaError) Error() string {
+	msgs := make([]string, 0, 3)
+	for _, v := range e.violations[:min(len(e.violations), 3)] {
+		ptr := v.Pointer
+		if ptr == "" {
+			ptr = "document"
+		}
+		msgs = append(msgs, ptr+": "+v.Message)
+	}
+	s := "response does not match schema: " + strings.Join(msgs, "; ")
+	if n := len(e.violations) - len(msgs); n > 0 {
+		s += fmt.Sprintf(" and %d more", n)
+	}
+	return s
+}
+
+// validate validates the document v, returning a *schemaError if it
+// is invalid.
+func (c *schemaConfig) validate(v any) error {
+	var vs []*violation
+	c.check(c.root, v, "", &vs, 0)
+	if len(vs) == 0 {
+		return nil
+	}
+	if len(vs) > maxViolations {
+		vs = vs[:maxViolations]
+	}
+	return &schemaError{violations: vs}
+}
+
+// check validates v, at the JSON pointer ptr, against the schema s,
+// appending violations to vs.
+func (c *schemaConfig) check(s, v any, ptr string, vs *[]*violation, depth int) {
+	report := func(format string, args ...any) {
+		*vs = append(*vs, &violation{Pointer: ptr, Message: fmt.Sprintf(format, args...)})
+	}
+	if depth > 64 {
+		report("schema nested too deeply")
+		return
+	}
+	if b, ok := s.(bool); ok {
+		if !b {
+			report("no value is allowed")
+		}
+		return
+	}
+	schema, ok := s.(map[string]any)
+	if !ok {
+		return
+	}
+	// valid reports whether v satisfies the subschema sub.
+	valid := func(sub any) bool {
+		var discard []*violation
+		c.check(sub, v, ptr, &discard, depth+1)
+		return len(discard) == 0
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		if sub, err := c.resolve(ref); err == nil {
+			c.check(sub, v, ptr, vs, depth+1)
+		}
+	}
+
+	if t, ok := schema["type"]; ok {
+		var types []string
+		switch t := t.(type) {
+		case string:
+			types = []string{t}
+		case []any:
+			for _, t := range t {
+				if t, ok := t.(string); ok {
+					types = append(types, t)
+				}
+			}
+		}
+		matched := false
+		for _, t := range types {
+			if t == jsonType(v) || t == "number" && jsonType(v) == "integer" {
+				matched = true
+			}
+		}
+		if !matched {
+			report("expected %s, got %s", strings.Join(types, " or "), jsonType(v))
+			return
+		}
+	}
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			if reflect.DeepEqual(e, v) {
+				found = true
+			}
+		}
+		if !found {
+			report("%s is not one of the allowed values", jsonString(v))
+		}
+	}
+	if want, ok := schema["const"]; ok && !reflect.DeepEqual(want, v) {
+		report("%s is not %s", jsonString(v), jsonString(want))
+	}
+
+	for _, k := range []string{"allOf", "anyOf", "oneOf"} {
+		subs, ok := schema[k].([]any)
+		if !ok {
+			continue
+		}
+		n := 0
+		for _, sub := range subs {
+			if k == "allOf" {
+				c.check(sub, v, ptr, vs, depth+1)
+			} else if valid(sub) {
+				n++
+			}
+		}
+		switch {
+		case k == "anyOf" && n == 0:
+			report("does not match any schema in anyOf")
+		case k == "oneOf" && n != 1:
+			report("matches %d schemas in oneOf, want 1", n)
+		}
+	}
+	if sub, ok := schema["not"]; ok && valid(sub) {
+		report("matches the schema in not")
+	}
+	if cond, ok := schema["if"]; ok {
+		if valid(cond) {
+			if sub, ok := schema["then"]; ok {
+				c.check(sub, v, ptr, vs, depth+1)
+			}
+		} else if sub, ok := schema["else"]; ok {
+			c.check(sub, v, ptr, vs, depth+1)
+		}
+	}
+
+	switch v := v.(type) {
+	case string:
+		n := float64(len([]rune(v)))
+		if limit, ok := schemaNumber(schema, "minLength"); ok && n < limit {
+			report("length %v is less than %v", n, limit)
+		}
+		if limit, ok := schemaNumber(schema, "maxLength"); ok && n > limit {
+			report("length %v is greater than %v", n, limit)
+		}
+		if p, ok := schema["pattern"].(string); ok && !c.patterns[p].MatchString(v) {
+			report("%s does not match %q", jsonString(v), p)
+		}
+	case float64:
+		if limit, ok := schemaNumber(schema, "minimum"); ok && v < limit {
+			report("%v is less than %v", v, limit)
+		}
+		if limit, ok := schemaNumber(schema, "maximum"); ok && v > limit {
+			report("%v is greater than %v", v, limit)
+		}
+		if limit, ok := schemaNumber(schema, "exclusiveMinimum"); ok && v <= limit {
+			report("%v is not greater than %v", v, limit)
+		}
+		if limit, ok := schemaNumber(schema, "exclusiveMaximum"); ok && v >= limit {
+			report("%v is not less than %v", v, limit)
+		}
+		if m, ok := schemaNumber(schema, "multipleOf"); ok && m > 0 {
+			if q := v / m; math.Abs(q-math.Round(q)) > 1e-9 {
+				report("%v is not a multiple of %v", v, m)
+			}
+		}
+	case []any:
+		n := float64(len(v))
+		if limit, ok := schemaNumber(schema, "minItems"); ok && n < limit {
+			report("has %v items, fewer than %v", n, limit)
+		}
+		if limit, ok := schemaNumber(schema, "maxItems"); ok && n > limit {
+			report("has %v items, more than %v", n, limit)
+		}
+		if unique, _ := schema["uniqueItems"].(bool); unique {
+		dupes:
+			for i := range v {
+				for j := 0; j < i; j++ {
+					if reflect.DeepEqual(v[i], v[j]) {
+						report("items %d and %d are equal", j, i)
+						break dupes
+					}
+				}
+			}
+		}
+		prefix, _ := schema["prefixItems"].([]any)
+		for i, item := range v {
+			p := ptr + "/" + strconv.Itoa(i)
+			if i < len(prefix) {
+				c.check(prefix[i], item, p, vs, depth+1)
+			} else if sub, ok := schema["items"]; ok {
+				c.check(sub, item, p, vs, depth+1)
+			}
+		}
+		if sub, ok := schema["contains"]; ok {
+			n := 0
+			for _, item := range v {
+				var discard []*violation
+				c.check(sub, item, ptr, &discard, depth+1)
+				if len(discard) == 0 {
+					n++
+				}
+			}
+			min, ok := schemaNumber(schema, "minContains")
+			if !ok {
+				min = 1
+			}
+			if float64(n) < min {
+				report("contains %d matching items, fewer than %v", n, min)
+			}
+			if max, ok := schemaNumber(schema, "maxContains"); ok && float64(n) > max {
+				report("contains %d matching items, more than %v", n, max)
+			}
+		}
+	case map[string]any:
+		n := float64(len(v))
+		if limit, ok := schemaNumber(schema, "minProperties"); ok && n < limit {
+			report("has %v properties, fewer than %v", n, limit)
+		}
+		if limit, ok := schemaNumber(schema, "maxProperties"); ok && n > limit {
+			report("has %v properties, more than %v", n, limit)
+		}
+		if required, ok := schema["required"].([]any); ok {
+			for _, name := range required {
+				if name, ok := name.(string); ok {
+					if _, ok := v[name]; !ok {
+						report("missing required property %q", name)
+					}
+				}
+			}
+		}
+		props, _ := schema["properties"].(map[string]any)
+		patternProps, _ := schema["patternProperties"].(map[string]any)
+		additional, hasAdditional := schema["additionalProperties"]
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			p := ptr + "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+			if sub, ok := schema["propertyNames"]; ok {
+				c.check(sub, name, p, vs, depth+1)
+			}
+			matched := false
+			if sub, ok := props[name]; ok {
+				matched = true
+				c.check(sub, v[name], p, vs, depth+1)
+			}
+			for pattern, sub := range patternProps {
+				if c.patterns[pattern].MatchString(name) {
+					matched = true
+					c.check(sub, v[name], p, vs, depth+1)
+				}
+			}
+			if !matched && hasAdditional {
+				if additional == false {
+					report("unexpected property %q", name)
+				} else {
+					c.check(additional, v[name], p, vs, depth+1)
+				}
+			}
+		}
+	}
+}
+
+// schemaNumber returns the numeric value of a schema keyword.
+func schemaNumber(schema map[string]any, keyword string) (float64, bool) {
+	n, ok := schema[keyword].(float64)
+	return n, ok
+}
+
+// jsonType returns the JSON Schema type of v, a value decoded from
+// JSON.
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return "unknown"
+}
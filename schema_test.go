@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newSchema returns the initialized schema given in JSON.
+func newSchema(t *testing.T, schema string) *schemaConfig {
+	t.Helper()
+	c := &schemaConfig{}
+	if err := json.Unmarshal([]byte(schema), c); err != nil {
+		t.Fatalf("parsing schema %s: %v", schema, err)
+	}
+	if err := c.init(""); err != nil {
+		t.Fatalf("schema %s: %v", schema, err)
+	}
+	return c
+}
+
+// violations validates the document doc against c, returning its
+// violations as "pointer: message".
+func violations(t *testing.T, c *schemaConfig, doc string) []string {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal([]byte(doc), &v); err != nil {
+		t.Fatalf("parsing %s: %v", doc, err)
+	}
+	err := c.validate(v)
+	if err == nil {
+		return nil
+	}
+	var se *schemaError
+	if !errors.As(err, &se) {
+		t.Fatalf("validate returned %T, want *schemaError", err)
+	}
+	var vs []string
+	for _, v := range se.violations {
+		vs = append(vs, v.Pointer+": "+v.Message)
+	}
+	return vs
+}
+
+func TestSchema(t *testing.T) {
+	const user = `{
+		"type": "object",
+		"required": ["id", "name"],
+		"properties": {
+			"id": {"type": "integer", "minimum": 1},
+			"name": {"type": "string", "minLength": 1, "maxLength": 8},
+			"email": {"type": "string", "pattern": "^[^@]+@[^@]+$"},
+			"role": {"enum": ["admin", "user"]},
+			"tags": {"type": "array", "items": {"type": "string"}, "uniqueItems": true}
+		},
+		"additionalProperties": false
+	}`
+	tests := []struct {
+		schema string
+		doc    string
+		want   []string
+	}{
+		{user, `{"id": 1, "name": "ann", "email": "ann@example.com", "role": "admin", "tags": ["a", "b"]}`, nil},
+		{user, `[]`, []string{": expected object, got array"}},
+		{user, `{"name": ""}`, []string{
+			`: missing required property "id"`,
+			"/name: length 0 is less than 1",
+		}},
+		{user, `{"id": 0.5, "name": "annabelle!", "role": "root", "extra": 1}`, []string{
+			`: unexpected property "extra"`,
+			"/id: expected integer, got number",
+			"/name: length 10 is greater than 8",
+			`/role: "root" is not one of the allowed values`,
+		}},
+		{user, `{"id": 1, "name": "ann", "email": "ann", "tags": ["a", 1, "a"]}`, []string{
+			`/email: "ann" does not match "^[^@]+@[^@]+$"`,
+			"/tags: items 0 and 2 are equal",
+			"/tags/1: expected string, got integer",
+		}},
+
+		// Numbers.
+		{`{"type": "number", "exclusiveMinimum": 0, "maximum": 10, "multipleOf": 0.5}`, `2.5`, nil},
+		{`{"type": "number", "exclusiveMinimum": 0, "maximum": 10, "multipleOf": 0.5}`, `0`, []string{": 0 is not greater than 0"}},
+		{`{"type": "number", "exclusiveMinimum": 0, "maximum": 10, "multipleOf": 0.5}`, `10.25`, []string{
+			": 10.25 is greater than 10",
+			": 10.25 is not a multiple of 0.5",
+		}},
+		{`{"type": ["integer", "null"]}`, `null`, nil},
+		{`{"type": ["integer", "null"]}`, `"1"`, []string{": expected integer or null, got string"}},
+		{`{"const": {"a": [1]}}`, `{"a": [1]}`, nil},
+		{`{"const": {"a": [1]}}`, `{"a": [2]}`, []string{`: {"a":[2]} is not {"a":[1]}`}},
+
+		// Arrays.
+		{`{"prefixItems": [{"type": "string"}], "items": {"type": "integer"}, "minItems": 2}`, `["a", 1, 2]`, nil},
+		{`{"prefixItems": [{"type": "string"}], "items": {"type": "integer"}, "minItems": 2}`, `[1]`, []string{
+			": has 1 items, fewer than 2",
+			"/0: expected string, got integer",
+		}},
+		{`{"contains": {"const": 1}, "maxContains": 1}`, `[1, 2]`, nil},
+		{`{"contains": {"const": 1}, "maxContains": 1}`, `[2, 3]`, []string{": contains 0 matching items, fewer than 1"}},
+		{`{"contains": {"const": 1}, "maxContains": 1}`, `[1, 1]`, []string{": contains 2 matching items, more than 1"}},
+
+		// Objects.
+		{`{"patternProperties": {"^x-": {"type": "string"}}, "additionalProperties": {"type": "integer"}}`, `{"x-a": "1", "b": 2}`, nil},
+		{`{"patternProperties": {"^x-": {"type": "string"}}, "additionalProperties": {"type": "integer"}}`, `{"x-a": 1, "b": "2"}`, []string{
+			"/b: expected integer, got string",
+			"/x-a: expected string, got integer",
+		}},
+		{`{"propertyNames": {"maxLength": 2}, "maxProperties": 1}`, `{"a/b": 1, "c~": 2}`, []string{
+			": has 2 properties, more than 1",
+			"/a~1b: length 3 is greater than 2",
+		}},
+
+		// Combinators.
+		{`{"anyOf": [{"type": "string"}, {"type": "integer"}]}`, `true`, []string{": does not match any schema in anyOf"}},
+		{`{"oneOf": [{"type": "integer"}, {"minimum": 0}]}`, `-1`, nil},
+		{`{"oneOf": [{"type": "integer"}, {"minimum": 0}]}`, `1`, []string{": matches 2 schemas in oneOf, want 1"}},
+		{`{"allOf": [{"minimum": 0}, {"maximum": 5}]}`, `6`, []string{": 6 is greater than 5"}},
+		{`{"not": {"type": "null"}}`, `null`, []string{": matches the schema in not"}},
+		{`{"if": {"minimum": 10}, "then": {"multipleOf": 10}, "else": {"maximum": 5}}`, `20`, nil},
+		{`{"if": {"minimum": 10}, "then": {"multipleOf": 10}, "else": {"maximum": 5}}`, `15`, []string{": 15 is not a multiple of 10"}},
+		{`{"if": {"minimum": 10}, "then": {"multipleOf": 10}, "else": {"maximum": 5}}`, `7`, []string{": 7 is greater than 5"}},
+		{`{"properties": {"a": false, "b": true}}`, `{"a": 1, "b": 2}`, []string{"/a: no value is allowed"}},
+
+		// References.
+		{`{"$defs": {"node": {"type": "object", "properties": {"next": {"$ref": "#/$defs/node"}}, "required": ["v"]}}, "$ref": "#/$defs/node"}`,
+			`{"v": 1, "next": {"v": 2, "next": {}}}`, []string{`/next/next: missing required property "v"`}},
+		{`{"items": [{"type": "string"}], "prefixItems": [{"$ref": "#/items/0"}]}`, `[1]`, []string{"/0: expected string, got integer"}},
+		{`{"$defs": {"a/b": {"type": "string"}}, "$ref": "#/$defs/a~1b"}`, `1`, []string{": expected string, got integer"}},
+		{`{"properties": {"self": {"$ref": "#"}}, "type": "object"}`, `{"self": {"self": 1}}`, []string{"/self/self: expected object, got integer"}},
+		{`{"$ref": "#"}`, `1`, []string{": schema nested too deeply"}},
+	}
+	for _, tt := range tests {
+		c := newSchema(t, tt.schema)
+		got := violations(t, c, tt.doc)
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("validating %s against %s:\ngot  %q\nwant %q", tt.doc, tt.schema, got, tt.want)
+		}
+	}
+}
+
+func TestSchemaInit(t *testing.T) {
+	for _, schema := range []string{
+		`{"pattern": "("}`,
+		`{"patternProperties": {"[": {}}}`,
+		`{"$ref": "#/$defs/missing"}`,
+		`{"items": {"$ref": "other.json#/user"}}`,
+		`{"$ref": "#/items/1", "items": [{}]}`,
+		`"/nonexistent/schema.json"`,
+	} {
+		c := &schemaConfig{}
+		if err := json.Unmarshal([]byte(schema), c); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.init(""); err == nil {
+			t.Errorf("schema %s: init succeeded, want error", schema)
+		}
+	}
+
+	// Schemas may be given as the path of a file.
+	file := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(file, []byte(`{"type": "string"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(file)
+	c := newSchema(t, string(b))
+	if got := violations(t, c, `1`); len(got) != 1 {
+		t.Errorf("violations = %q, want 1", got)
+	}
+}
+
+func TestSchemaError(t *testing.T) {
+	c := newSchema(t, `{"items": {"type": "string"}}`)
+	err := c.validate([]any{1.0, 2.0, 3.0, 4.0, 5.0})
+	want := "response does not match schema: /0: expected string, got integer; " +
+		"/1: expected string, got integer; /2: expected string, got integer and 2 more"
+	if err == nil || err.Error() != want {
+		t.Errorf("error = %v, want %s", err, want)
+	}
+
+	doc := make([]any, 2*maxViolations)
+	if err := c.validate(doc); err == nil || len(err.(*schemaError).violations) != maxViolations {
+		t.Errorf("error = %v, want %d violations", err, maxViolations)
+	}
+}
+
+func TestSchemaFileRelative(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "schemas"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "schemas", "user.json")
+	if err := os.WriteFile(file, []byte(`{"type": "object", "required": ["name"]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Relative paths are resolved against the directory of the
+	// services file, not the working directory.
+	for _, path := range []string{"schemas/user.json", "./schemas/../schemas/user.json", file} {
+		data := fmt.Sprintf(`[{"name": "users", "url": "http://users.invalid/", "schema": %q}]`, path)
+		cfg, err := parseConfig([]byte(data), dir)
+		if err != nil {
+			t.Errorf("schema %s: %v", path, err)
+			continue
+		}
+		c := cfg.Services[0].Schema
+		if c.File != file {
+			t.Errorf("schema %s read from %s, want %s", path, c.File, file)
+		}
+		if got := violations(t, c, `{}`); len(got) != 1 {
+			t.Errorf("schema %s: violations = %q, want 1", path, got)
+		}
+	}
+
+	data := `[{"name": "users", "url": "http://users.invalid/", "schema": "user.json"}]`
+	if _, err := parseConfig([]byte(data), dir); err == nil || !strings.Contains(err.Error(), filepath.Join(dir, "user.json")) {
+		t.Errorf("missing schema: error %v, want one naming %s", err, filepath.Join(dir, "user.json"))
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// secret is a configuration value that may be given literally, or
+// referenced as "env:NAME", to read it from an environment variable,
+// or "file:PATH", to read it from a file.
+type secret string
+
+// value returns the secret's value.
+func (s secret) value() (string, error) {
+	switch {
+	case strings.HasPrefix(string(s), "env:"):
+		name := strings.TrimPrefix(string(s), "env:")
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return v, nil
+	case strings.HasPrefix(string(s), "file:"):
+		b, err := os.ReadFile(strings.TrimPrefix(string(s), "file:"))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	return string(s), nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// authConfig configures the authentication of requests to an HTTP
+// service. The only type is "sigv4", AWS Signature Version 4.
+type authConfig struct {
+	Type string `json:"type"`
+	sigv4
+}
+
+func (a *authConfig) init() error {
+	if a.Type != "sigv4" {
+		return fmt.Errorf("unknown auth type %q", a.Type)
+	}
+	return a.sigv4.init()
+}
+
+// sigv4 holds the settings for signing requests with AWS Signature
+// Version 4. Credentials are read from the AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables
+// unless an access key is given.
+type sigv4 struct {
+	Region       string `json:"region,omitempty"`
+	Service      string `json:"service,omitempty"`
+	AccessKey    secret `json:"access_key,omitempty"`
+	SecretKey    secret `json:"secret_key,omitempty"`
+	SessionToken secret `json:"session_token,omitempty"`
+}
+
+func (v *sigv4) init() error {
+	if v.Region == "" {
+		v.Region = "us-east-1"
+	}
+	if v.Service == "" {
+		v.Service = "s3"
+	}
+	if v.AccessKey == "" {
+		if v.SecretKey != "" || v.SessionToken != "" {
+			return fmt.Errorf("sigv4 requires an access key with a secret key or session token")
+		}
+		v.AccessKey = "env:AWS_ACCESS_KEY_ID"
+		v.SecretKey = "env:AWS_SECRET_ACCESS_KEY"
+		if _, ok := os.LookupEnv("AWS_SESSION_TOKEN"); ok {
+			v.SessionToken = "env:AWS_SESSION_TOKEN"
+		}
+	}
+	if v.SecretKey == "" {
+		return fmt.Errorf("sigv4 requires a secret key with an access key")
+	}
+	return nil
+}
+
+// emptySHA256 is the hex encoded SHA-256 hash of an empty payload.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign adds a Signature Version 4 Authorization header to req. Any
+// body must be replayable through req.GetBody, as it is for bodies
+// given to http.NewRequest as a bytes.Reader. The host and every
+// header already set on the request, other than User-Agent, are
+// signed.
+func (v *sigv4) sign(req *http.Request, now time.Time) error {
+	accessKey, err := v.AccessKey.value()
+	if err != nil {
+		return err
+	}
+	secretKey, err := v.SecretKey.value()
+	if err != nil {
+		return err
+	}
+	token, err := v.SessionToken.value()
+	if err != nil {
+		return err
+	}
+
+	payloadHash := emptySHA256
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		io.Copy(h, body)
+		payloadHash = hex.EncodeToString(h.Sum(nil))
+	}
+
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for k, vs := range req.Header {
+		k = strings.ToLower(k)
+		if k == "user-agent" || k == "authorization" {
+			continue
+		}
+		values := make([]string, len(vs))
+		for i, v := range vs {
+			values[i] = strings.Join(strings.Fields(v), " ")
+		}
+		headers[k] = strings.Join(values, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", k, headers[k])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		v.canonicalPath(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := strings.Join([]string{date, v.Region, v.Service, "aws4_request"}, "/")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + secretKey)
+	for _, s := range []string{date, v.Region, v.Service, "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+	return nil
+}
+
+// canonicalPath returns the path of a request encoded as Signature
+// Version 4 requires: once for S3, and twice for other services.
+func (v *sigv4) canonicalPath(path string) string {
+	if path == "" {
+		path = "/"
+	}
+	path = uriEncode(path, false)
+	if v.Service != "s3" {
+		path = uriEncode(path, false)
+	}
+	return path
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery returns the query parameters sorted and encoded as
+// Signature Version 4 requires.
+func canonicalQuery(q url.Values) string {
+	var params []string
+	for k, vs := range q {
+		for _, v := range vs {
+			params = append(params, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// uriEncode percent-encodes every byte of s other than unreserved
+// characters, and slashes unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
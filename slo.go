@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// defaultSLOWindow is the window an SLO is computed over when none
+// is specified.
+const defaultSLOWindow = 30 * 24 * time.Hour
+
+// slo is a service level objective for a service. Without a latency
+// threshold it is an availability objective, for which checks are
+// good unless the service is down. With a latency threshold, checks
+// are good only if the service is up and responded within the
+// threshold.
+type slo struct {
+	Name string `json:"name,omitempty"`
+	// Objective is the percentage of checks that must be good.
+	Objective float64   `json:"objective"`
+	Latency   *duration `json:"latency,omitempty"`
+	Window    *duration `json:"window,omitempty"`
+	// MinFailures is the number of failed checks needed within a
+	// burn rate alert's long window before the alert fires. It
+	// defaults to 2, so a single failed check never alerts.
+	MinFailures int `json:"min_failures,omitempty"`
+}
+
+// burnWindow describes a multi-window burn rate alert. The alert
+// fires when the error budget is being consumed at Factor times the
+// sustainable rate over both the long and short windows.
+type burnWindow struct {
+	Name     string
+	Long     time.Duration
+	Short    time.Duration
+	Factor   float64
+	Severity string
+}
+
+// burnWindows are the burn rate alerts evaluated for each SLO. The
+// fast burn consumes 2% of a 30 day budget in an hour, and the slow
+// burn 5% in six hours.
+var burnWindows = []burnWindow{
+	{Name: "fast", Long: time.Hour, Short: 5 * time.Minute, Factor: 14.4, Severity: "critical"},
+	{Name: "slow", Long: 6 * time.Hour, Short: 30 * time.Minute, Factor: 6, Severity: "warning"},
+}
+
+func (o *slo) init() error {
+	if o.Objective <= 0 || o.Objective >= 100 {
+		return fmt.Errorf("slo objective must be between 0 and 100 exclusive")
+	}
+	if o.Name == "" {
+		if o.Latency != nil {
+			o.Name = "latency"
+		} else {
+			o.Name = "availability"
+		}
+	}
+	if o.Window == nil {
+		w := duration(defaultSLOWindow)
+		o.Window = &w
+	}
+	if o.MinFailures == 0 {
+		o.MinFailures = 2
+	}
+	return nil
+}
+
+// good reports whether a check counts towards meeting the objective.
+func (o *slo) good(rec *record) bool {
+	if o.Latency == nil {
+		return rec.State != stateDown
+	}
+	return rec.State == stateUp &&
+		time.Duration(rec.Latency)*time.Millisecond <= time.Duration(*o.Latency)
+}
+
+// count returns the number of checks and failed checks in records
+// made at or after since.
+func (o *slo) count(records []*record, since time.Time) (total, bad int) {
+	for _, rec := range records {
+		if rec.Time.Before(since) {
+			continue
+		}
+		total++
+		if !o.good(rec) {
+			bad++
+		}
+	}
+	return total, bad
+}
+
+// burnRate returns the rate at which the error budget is consumed
+// by records made at or after since, relative to the rate that would
+// exactly exhaust it over the SLO window.
+func (o *slo) burnRate(records []*record, since time.Time) (rate float64, bad int) {
+	total, bad := o.count(records, since)
+	if total == 0 {
+		return 0, 0
+	}
+	return float64(bad) / float64(total) / o.budget(), bad
+}
+
+// budget returns the fraction of checks that may fail while meeting
+// the objective.
+func (o *slo) budget() float64 {
+	return (100 - o.Objective) / 100
+}
+
+// sloWindow returns the longest SLO window of any service, and at
+// least historyRetention.
+func sloWindow(services []*service) time.Duration {
+	window := historyRetention
+	for _, s := range services {
+		for _, o := range s.SLOs {
+			window = max(window, time.Duration(*o.Window))
+		}
+	}
+	return window
+}
+
+// burnAlerts returns alerts for SLOs whose error budgets are being
+// consumed too quickly, for services within their active hours.
+func burnAlerts(services []*service, results []*result, records map[string][]*record, now time.Time) []*alert {
+	var alerts []*alert
+	for i, s := range services {
+		if !s.active(now) {
+			continue
+		}
+		for _, o := range s.SLOs {
+			for _, w := range burnWindows {
+				long, bad := o.burnRate(records[s.Name], now.Add(-w.Long))
+				short, _ := o.burnRate(records[s.Name], now.Add(-w.Short))
+				if bad < o.MinFailures || long < w.Factor || short < w.Factor {
+					continue
+				}
+				a := newAlert(s, "burn_rate", results[i].State,
+					fmt.Sprintf("%s SLO error budget burning at %.1fx (%s burn)",
+						o.Name, long, w.Name))
+				a.Labels["slo"] = o.Name
+				a.Labels["burn"] = w.Name
+				a.Labels["severity"] = w.Severity
+				alerts = append(alerts, a)
+				// Only alert on the fastest burn.
+				break
+			}
+		}
+	}
+	return alerts
+}
+
+// sloCommand implements the "slo" command, which prints the error
+// budget remaining for each service's SLOs.
+func sloCommand(cfg *config, dir string, now time.Time) error {
+	records, err := readHistory(dir, now.Add(-sloWindow(cfg.Services)))
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.StripEscape)
+	fmt.Fprintln(w, "SERVICE\tSLO\tOBJECTIVE\tWINDOW\tCHECKS\tACTUAL\tBUDGET REMAINING")
+	for _, s := range cfg.Services {
+		for _, o := range s.SLOs {
+			window := time.Duration(*o.Window)
+			total, bad := o.count(records[s.Name], now.Add(-window))
+			actual, remaining := "-", "-"
+			if total > 0 {
+				failed := float64(bad) / float64(total)
+				actual = fmt.Sprintf("%.3f%%", 100*(1-failed))
+				remaining = fmt.Sprintf("%.1f%%", 100*(1-failed/o.budget()))
+			}
+			fmt.Fprintf(w, "%s\t%s\t%g%%\t%s\t%d\t%s\t%s\n",
+				s.Name, o.Name, o.Objective, formatDuration(window), total, actual, remaining)
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// checks returns the records of a check made every minute for n
+// minutes up to now, oldest first, with state returning the state of
+// the check made i minutes before now.
+func checks(now time.Time, n int, state func(i int) string) []*record {
+	var records []*record
+	for i := n - 1; i >= 0; i-- {
+		records = append(records, &record{
+			Time:    now.Add(-time.Duration(i) * time.Minute),
+			State:   state(i),
+			Latency: 100,
+		})
+	}
+	return records
+}
+
+// failing returns a state function for checks that fail when fail
+// reports true for the minutes since they were made.
+func failing(fail func(i int) bool) func(int) string {
+	return func(i int) string {
+		if fail(i) {
+			return stateDown
+		}
+		return stateUp
+	}
+}
+
+func TestSLOBurnRate(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	latency := duration(200 * time.Millisecond)
+	records := []*record{
+		{Time: now.Add(-20 * time.Minute), State: stateDown},
+		{Time: now.Add(-10 * time.Minute), State: stateDown},
+		{Time: now.Add(-9 * time.Minute), State: stateUp, Latency: 100},
+		{Time: now.Add(-8 * time.Minute), State: stateDegraded, Latency: 100},
+		{Time: now.Add(-7 * time.Minute), State: stateUp, Latency: 200},
+		{Time: now.Add(-6 * time.Minute), State: stateUp, Latency: 201},
+		{Time: now.Add(-5 * time.Minute), State: stateUp, Latency: 100},
+		{Time: now.Add(-4 * time.Minute), State: stateUp, Latency: 100},
+		{Time: now.Add(-3 * time.Minute), State: stateUp, Latency: 100},
+		{Time: now.Add(-2 * time.Minute), State: stateUp, Latency: 100},
+		{Time: now.Add(-1 * time.Minute), State: stateUp, Latency: 100},
+	}
+	tests := []struct {
+		slo   *slo
+		since time.Duration
+		rate  float64
+		bad   int
+	}{
+		// Records made exactly at since are counted: 1 of 10 checks
+		// failed, ten times the 1% budget.
+		{&slo{Objective: 99}, 10 * time.Minute, 10, 1},
+		{&slo{Objective: 90}, 10 * time.Minute, 1, 1},
+		{&slo{Objective: 99.9}, 10 * time.Minute, 100, 1},
+		{&slo{Objective: 99}, 20 * time.Minute, 2 / 11. / 0.01, 2},
+		{&slo{Objective: 99}, 9 * time.Minute, 0, 0},
+		{&slo{Objective: 99}, 30 * time.Second, 0, 0},
+		// Latency SLOs fail checks that aren't up or are slower than
+		// the threshold.
+		{&slo{Objective: 99, Latency: &latency}, 10 * time.Minute, 30, 3},
+		{&slo{Objective: 99, Latency: &latency}, 7 * time.Minute, 1 / 7. / 0.01, 1},
+	}
+	for _, tt := range tests {
+		if err := tt.slo.init(); err != nil {
+			t.Fatal(err)
+		}
+		rate, bad := tt.slo.burnRate(records, now.Add(-tt.since))
+		if math.Abs(rate-tt.rate) > 1e-9 || bad != tt.bad {
+			t.Errorf("%s %g%% since %s: burn rate %g with %d failures, want %g with %d",
+				tt.slo.Name, tt.slo.Objective, tt.since, rate, bad, tt.rate, tt.bad)
+		}
+	}
+}
+
+func TestSLOInit(t *testing.T) {
+	latency := duration(time.Second)
+	for _, tt := range []struct {
+		slo         *slo
+		name        string
+		minFailures int
+	}{
+		{&slo{Objective: 99.9}, "availability", 2},
+		{&slo{Objective: 99, Latency: &latency}, "latency", 2},
+		{&slo{Objective: 99, Name: "api", MinFailures: 5}, "api", 5},
+	} {
+		if err := tt.slo.init(); err != nil {
+			t.Fatal(err)
+		}
+		if tt.slo.Name != tt.name || tt.slo.MinFailures != tt.minFailures ||
+			time.Duration(*tt.slo.Window) != defaultSLOWindow {
+			t.Errorf("slo = %s, %d failures, window %s, want %s, %d, %s",
+				tt.slo.Name, tt.slo.MinFailures, time.Duration(*tt.slo.Window),
+				tt.name, tt.minFailures, defaultSLOWindow)
+		}
+	}
+	for _, objective := range []float64{0, -1, 100, 101} {
+		if err := (&slo{Objective: objective}).init(); err == nil {
+			t.Errorf("objective %g accepted, want error", objective)
+		}
+	}
+}
+
+func TestBurnAlerts(t *testing.T) {
+	cfg := newFakeNotifiers(t).config(t, `{}`,
+		`{"name": "api", "url": "http://api.invalid/", "slos": [{"objective": 99.9}]}`,
+		`{"name": "batch", "url": "http://batch.invalid/", "slos": [{"objective": 99.9, "min_failures": 1}]}`,
+		`{"name": "office", "url": "http://office.invalid/", "timezone": "UTC",
+			"active_hours": "* 9-17 * * 1-5", "slos": [{"objective": 99.9}]}`,
+	)
+	// A Friday afternoon, within office's active hours, and a
+	// Saturday, outside them.
+	friday := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	saturday := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		now  time.Time
+		fail func(i int) bool
+		want []string
+	}{
+		{"no failures", friday, func(int) bool { return false }, nil},
+		// A single failure burns quickly enough, but is too few.
+		{"one failure", friday, func(i int) bool { return i == 2 }, []string{
+			"batch fast critical",
+		}},
+		// Both burns fire, but only the fast burn alerts.
+		{"fast burn", friday, func(i int) bool { return i < 5 }, []string{
+			"api fast critical",
+			"batch fast critical",
+			"office fast critical",
+		}},
+		// Failures every 20 minutes, none in the last five: the fast
+		// burn's short window has recovered, the slow burn's hasn't.
+		{"slow burn", friday, func(i int) bool { return i%20 == 10 }, []string{
+			"api slow warning",
+			"batch slow warning",
+			"office slow warning",
+		}},
+		// Failures long enough ago to have left both short windows.
+		{"recovered", friday, func(i int) bool { return i >= 45 && i < 60 }, nil},
+		{"inactive", saturday, func(i int) bool { return i < 5 }, []string{
+			"api fast critical",
+			"batch fast critical",
+		}},
+	}
+	for _, tt := range tests {
+		records := make(map[string][]*record)
+		var results []*result
+		for _, s := range cfg.Services {
+			records[s.Name] = checks(tt.now, 6*60, failing(tt.fail))
+			results = append(results, &result{Name: s.Name, State: stateUp})
+		}
+		var got []string
+		for _, a := range burnAlerts(cfg.Services, results, records, tt.now) {
+			got = append(got, a.Labels["service"]+" "+a.Labels["burn"]+" "+a.Labels["severity"])
+			if a.Labels["slo"] != "availability" {
+				t.Errorf("%s: alert for slo %q, want availability", tt.name, a.Labels["slo"])
+			}
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s: alerts %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSLOCommand(t *testing.T) {
+	dir := t.TempDir()
+	cfg := newFakeNotifiers(t).config(t, `{}`,
+		`{"name": "api", "url": "http://api.invalid/", "slos": [
+			{"objective": 90, "window": "4h"},
+			{"objective": 90, "latency": "150ms", "window": "4h"}
+		]}`,
+		`{"name": "idle", "url": "http://idle.invalid/", "slos": [{"objective": 99.9}]}`,
+	)
+	now := time.Date(2026, 10, 16, 1, 0, 0, 0, time.UTC)
+	// 20 checks within the window, which spans midnight, one of which
+	// failed and one of which was slow. The failed checks before the
+	// window aren't counted.
+	for _, i := range []int{0, 12, 24, 36, 48, 60, 72, 84, 96, 108, 120, 132, 144, 156, 168, 180, 192, 204, 216, 228, 250, 260} {
+		r := &result{Name: "api", State: stateUp, Latency: duration(100 * time.Millisecond)}
+		switch {
+		case i == 12 || i > 240:
+			r.State = stateDown
+		case i == 24:
+			r.Latency = duration(time.Second)
+		}
+		if err := appendHistory(dir, []*result{r}, now.Add(-time.Duration(i)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, err := captureStdout(t, func() error { return sloCommand(cfg, dir, now) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n")[1:] {
+		got = append(got, strings.Join(strings.Fields(line), " "))
+	}
+	want := []string{
+		// 5% of checks failed, half the 10% budget.
+		"api availability 90% 4h0m0s 20 95.000% 50.0%",
+		"api latency 90% 4h0m0s 20 90.000% 0.0%",
+		"idle availability 99.9% 30d 0 - -",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("slo printed:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
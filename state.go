@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// state holds information mon persists between runs.
+type state struct {
+	Groups        map[string]*groupState   `json:"groups,omitempty"`
+	Services      map[string]*serviceState `json:"services,omitempty"`
+	HistoryPruned time.Time                `json:"history_pruned"`
+
+	file string
+}
+
+// serviceState holds information persisted for a service.
+type serviceState struct {
+	Baseline     *baseline   `json:"baseline,omitempty"`
+	Failures     int         `json:"failures,omitempty"`
+	Remediations []time.Time `json:"remediations,omitempty"`
+	UnitRestarts *int        `json:"unit_restarts,omitempty"`
+	Log          *logState   `json:"log,omitempty"`
+	Domain       *domainInfo `json:"domain,omitempty"`
+	RDAP         *rdapState  `json:"rdap,omitempty"`
+
+	// Checked is when the service was last checked. Last is the
+	// result of that check, kept for services with a schedule or
+	// interval to report until they are next checked.
+	Checked time.Time `json:"checked"`
+	Last    *result   `json:"last,omitempty"`
+}
+
+// service returns the state for the named service, creating it if
+// necessary.
+func (st *state) service(name string) *serviceState {
+	ss := st.Services[name]
+	if ss == nil {
+		ss = &serviceState{}
+		st.Services[name] = ss
+	}
+	return ss
+}
+
+// loadState reads the state file from dir. A missing state file
+// results in empty state.
+func loadState(dir string) (*state, error) {
+	st := &state{file: filepath.Join(dir, "state.json")}
+	data, err := os.ReadFile(st.file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, st); err != nil {
+			return nil, err
+		}
+	}
+	if st.Groups == nil {
+		st.Groups = make(map[string]*groupState)
+	}
+	if st.Services == nil {
+		st.Services = make(map[string]*serviceState)
+	}
+	return st, nil
+}
+
+// save writes the state file, replacing it atomically.
+func (st *state) save() error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := st.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, st.file)
+}
+
+// getStateDir checks if the mon state directory exists, and
+// creates it if not. It returns the full path to the state directory.
+func getStateDir() (string, error) {
+	dir, err := getConfigDir()
+	if err != nil {
+		return "", err
+	}
+	stateDir := filepath.Join(dir, "state")
+	err = os.Mkdir(stateDir, 0700)
+	if err != nil && !os.IsExist(err) {
+		return "", err
+	}
+	return stateDir, nil
+}
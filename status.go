@@ -0,0 +1,172 @@
+package main
+
+import (
+	"time"
+)
+
+// healthStatuses maps states to the statuses of the health check
+// response format for HTTP APIs (draft-inadarei-api-health-check),
+// served as application/health+json.
+var healthStatuses = map[string]string{
+	stateUp:       "pass",
+	stateDegraded: "warn",
+	stateDown:     "fail",
+}
+
+// health is the aggregate status of all services in the health check
+// response format.
+type health struct {
+	Status      string                    `json:"status"`
+	Description string                    `json:"description"`
+	Checks      map[string][]*healthCheck `json:"checks"`
+}
+
+// healthCheck is the status of a single measurement of a service.
+type healthCheck struct {
+	ComponentID   string  `json:"componentId"`
+	ComponentType string  `json:"componentType,omitempty"`
+	ObservedValue float64 `json:"observedValue"`
+	ObservedUnit  string  `json:"observedUnit,omitempty"`
+	Status        string  `json:"status"`
+	Time          string  `json:"time"`
+	Output        string  `json:"output,omitempty"`
+}
+
+// newHealth returns the aggregate status of the services checked.
+// Each service's response time is reported under its name, and each
+// of its metrics under its name followed by the metric's, at the time
+// the service was checked.
+func newHealth(results []*result) *health {
+	h := &health{
+		Description: "services checked by mon",
+		Checks:      make(map[string][]*healthCheck),
+	}
+	state := stateUp
+	for _, r := range results {
+		state = worse(state, r.State)
+		c := &healthCheck{
+			ComponentID:   r.Name,
+			ComponentType: r.service.Type,
+			ObservedValue: float64(time.Duration(r.Latency).Milliseconds()),
+			ObservedUnit:  "ms",
+			Status:        healthStatuses[r.State],
+			Time:          r.checked.Format(time.RFC3339),
+		}
+		if r.State != stateUp {
+			c.Output = r.summary()
+		}
+		h.Checks[r.Name+":responseTime"] = []*healthCheck{c}
+		for _, m := range r.Metrics {
+			mc := &healthCheck{
+				ComponentID:   r.Name,
+				ComponentType: r.service.Type,
+				ObservedValue: m.Value,
+				ObservedUnit:  m.Unit,
+				Status:        healthStatuses[stateUp],
+				Time:          c.Time,
+			}
+			if m.State != "" {
+				mc.Status = healthStatuses[m.State]
+			}
+			h.Checks[r.Name+":"+m.Name] = []*healthCheck{mc}
+		}
+	}
+	h.Status = healthStatuses[state]
+	return h
+}
+
+// pageStatus is the aggregate status of all services in the format
+// of a Statuspage status.json.
+type pageStatus struct {
+	Page   *page `json:"page"`
+	Status struct {
+		Indicator   string `json:"indicator"`
+		Description string `json:"description"`
+	} `json:"status"`
+}
+
+// pageSummary is the status of all services in the format of a
+// Statuspage summary.json. Services are reported as components; mon
+// has no notion of incidents or maintenance, so none are reported.
+type pageSummary struct {
+	pageStatus
+	Components            []*component `json:"components"`
+	Incidents             []any        `json:"incidents"`
+	ScheduledMaintenances []any        `json:"scheduled_maintenances"`
+}
+
+type page struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+type component struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Status      string `json:"status"`
+	Description string `json:"description,omitempty"`
+	Position    int    `json:"position"`
+	UpdatedAt   string `json:"updated_at"`
+	Group       bool   `json:"group"`
+}
+
+// componentStatuses maps states to the statuses of Statuspage
+// components.
+var componentStatuses = map[string]string{
+	stateUp:       "operational",
+	stateDegraded: "degraded_performance",
+	stateDown:     "major_outage",
+}
+
+// newPageSummary returns the status of the services checked as a
+// Statuspage summary. Components are updated when their service was
+// checked, and the page when the most recent was. The page's
+// indicator is minor if any service is degraded, major if any is
+// down, and critical if all are.
+func newPageSummary(results []*result, now time.Time) *pageSummary {
+	p := &pageSummary{
+		Components:            []*component{},
+		Incidents:             []any{},
+		ScheduledMaintenances: []any{},
+	}
+	var updated time.Time
+	var degraded, down int
+	for i, r := range results {
+		c := &component{
+			ID:        r.Name,
+			Name:      r.Name,
+			Status:    componentStatuses[r.State],
+			Position:  i + 1,
+			UpdatedAt: r.checked.Format(time.RFC3339),
+		}
+		if r.checked.After(updated) {
+			updated = r.checked
+		}
+		switch r.State {
+		case stateDegraded:
+			degraded++
+			c.Description = r.summary()
+		case stateDown:
+			down++
+			c.Description = r.summary()
+		}
+		p.Components = append(p.Components, c)
+	}
+	if updated.IsZero() {
+		updated = now
+	}
+	p.Page = &page{ID: "mon", Name: "mon", UpdatedAt: updated.Format(time.RFC3339)}
+	s := &p.Status
+	switch {
+	case down > 0 && down == len(results):
+		s.Indicator, s.Description = "critical", "Major System Outage"
+	case down > 0:
+		s.Indicator, s.Description = "major", "Partial System Outage"
+	case degraded > 0:
+		s.Indicator, s.Description = "minor", "Partially Degraded Service"
+	default:
+		s.Indicator, s.Description = "none", "All Systems Operational"
+	}
+	return p
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatusTimes(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	earlier := now.Add(-time.Hour)
+	results := []*result{
+		{Name: "api", State: stateUp, checked: now, service: &service{Type: "http"}},
+		{
+			Name:    "backup",
+			State:   stateDegraded,
+			Message: "slow response",
+			Stale:   true,
+			Metrics: []*metric{{Name: "age", Value: 3, Unit: "h", State: stateDegraded}},
+			checked: earlier,
+			service: &service{Type: "http"},
+		},
+	}
+
+	h := newHealth(results)
+	if h.Status != "warn" {
+		t.Errorf("health status = %s, want warn", h.Status)
+	}
+	for key, want := range map[string]time.Time{
+		"api:responseTime":    now,
+		"backup:responseTime": earlier,
+		"backup:age":          earlier,
+	} {
+		if c := h.Checks[key]; len(c) != 1 || c[0].Time != want.Format(time.RFC3339) {
+			t.Errorf("%s = %+v, want time %s", key, c, want.Format(time.RFC3339))
+		}
+	}
+
+	p := newPageSummary(results, now.Add(time.Minute))
+	if p.Page.UpdatedAt != now.Format(time.RFC3339) {
+		t.Errorf("page updated at %s, want %s", p.Page.UpdatedAt, now.Format(time.RFC3339))
+	}
+	if p.Status.Indicator != "minor" {
+		t.Errorf("indicator = %s, want minor", p.Status.Indicator)
+	}
+	for i, want := range []time.Time{now, earlier} {
+		if c := p.Components[i]; c.UpdatedAt != want.Format(time.RFC3339) {
+			t.Errorf("component %s updated at %s, want %s", c.Name, c.UpdatedAt, want.Format(time.RFC3339))
+		}
+	}
+	if c := p.Components[1]; c.Status != "degraded_performance" || c.Description != "slow response" {
+		t.Errorf("component %s = %s %q, want degraded_performance", c.Name, c.Status, c.Description)
+	}
+}
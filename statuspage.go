@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// statuspageCheck configures a check of a vendor's status page, as
+// hosted by Statuspage, from its summary. The service's URL is either
+// the page's URL or that of its summary.json.
+type statuspageCheck struct {
+	// Components are the names of the components, or groups of
+	// components, to consider. If empty, all are considered.
+	Components []string `json:"components,omitempty"`
+	// Feed is the URL of the page's Atom feed of incidents, such as
+	// https://www.githubstatus.com/history.atom. Incidents in the
+	// feed that are not resolved are reported alongside those in
+	// the summary.
+	Feed string `json:"feed,omitempty"`
+}
+
+func (c *statuspageCheck) init() error {
+	if c.Feed != "" {
+		if _, err := url.Parse(c.Feed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// summaryURL returns the URL of the summary of the status page at u.
+func summaryURL(u string) (string, error) {
+	p, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(p.Path, ".json") {
+		p.Path = strings.TrimSuffix(p.Path, "/") + "/api/v2/summary.json"
+	}
+	return p.String(), nil
+}
+
+// statuspageSummary is the part of a status page's summary used.
+type statuspageSummary struct {
+	Components []struct {
+		ID      string `json:"id"`
+		Name    string `json:"name"`
+		Status  string `json:"status"`
+		Group   bool   `json:"group"`
+		GroupID string `json:"group_id"`
+	} `json:"components"`
+	// Incidents are those not yet resolved.
+	Incidents []struct {
+		Name       string `json:"name"`
+		Impact     string `json:"impact"`
+		Components []struct {
+			ID string `json:"id"`
+		} `json:"components"`
+	} `json:"incidents"`
+}
+
+// componentStates maps the statuses of components to states.
+var componentStates = map[string]string{
+	"operational":          stateUp,
+	"under_maintenance":    stateDegraded,
+	"degraded_performance": stateDegraded,
+	"partial_outage":       stateDegraded,
+	"major_outage":         stateDown,
+}
+
+// impactStates maps the impacts of incidents to states.
+var impactStates = map[string]string{
+	"none":     stateUp,
+	"minor":    stateDegraded,
+	"major":    stateDown,
+	"critical": stateDown,
+}
+
+// checkStatuspage reads the status page's summary, and the state of
+// the service is the worst of the states of its components and the
+// impact of its unresolved incidents. Incidents that list components
+// are only considered if they affect one of the components checked.
+func checkStatuspage(s *service, _ *serviceState, r *result) {
+	c := s.StatusPage
+	resp, err := fetch(s, s.URL)
+	if err != nil {
+		r.Status = http.StatusServiceUnavailable
+		r.State = stateDown
+		r.Message = err.Error()
+		return
+	}
+	r.Status = resp.status
+	if resp.status != http.StatusOK {
+		r.State, r.Message = unexpected(resp.status)
+		return
+	}
+	var sum statuspageSummary
+	if err := json.Unmarshal([]byte(resp.body), &sum); err != nil {
+		r.State = stateDown
+		r.Message = fmt.Sprintf("invalid summary: %v", err)
+		return
+	}
+
+	// Select the named components, and the members of any named
+	// groups.
+	wanted := make(map[string]bool)
+	for _, name := range c.Components {
+		wanted[strings.ToLower(name)] = true
+	}
+	found := make(map[string]bool)
+	groups := make(map[string]bool)
+	for _, comp := range sum.Components {
+		if name := strings.ToLower(comp.Name); wanted[name] {
+			found[name] = true
+			if comp.Group {
+				groups[comp.ID] = true
+			}
+		}
+	}
+	selected := make(map[string]bool)
+	names := make(map[string]bool)
+	for _, comp := range sum.Components {
+		if len(wanted) == 0 || wanted[strings.ToLower(comp.Name)] || groups[comp.GroupID] {
+			selected[comp.ID] = true
+			names[strings.ToLower(comp.Name)] = true
+		}
+	}
+
+	r.State = stateUp
+	var msgs []string
+	for _, name := range c.Components {
+		if !found[strings.ToLower(name)] {
+			r.State = stateDown
+			msgs = append(msgs, fmt.Sprintf("no component %q", name))
+		}
+	}
+	for _, comp := range sum.Components {
+		if !selected[comp.ID] || comp.Group {
+			continue
+		}
+		state, ok := componentStates[comp.Status]
+		if !ok {
+			state = stateDegraded
+		}
+		if state != stateUp {
+			r.State = worse(r.State, state)
+			msgs = append(msgs, fmt.Sprintf("%s: %s", comp.Name, strings.ReplaceAll(comp.Status, "_", " ")))
+		}
+	}
+	incidents := make(map[string]bool)
+	for _, inc := range sum.Incidents {
+		incidents[inc.Name] = true
+		affected := len(inc.Components) == 0
+		for _, comp := range inc.Components {
+			affected = affected || selected[comp.ID]
+		}
+		if !affected {
+			continue
+		}
+		state, ok := impactStates[inc.Impact]
+		if !ok {
+			state = stateDegraded
+		}
+		r.State = worse(r.State, state)
+		msgs = append(msgs, fmt.Sprintf("incident: %s (%s impact)", inc.Name, inc.Impact))
+	}
+
+	if c.Feed != "" {
+		entries, err := feedIncidents(s, c.Feed)
+		if err != nil {
+			r.State = worse(r.State, stateDegraded)
+			msgs = append(msgs, fmt.Sprintf("feed: %v", err))
+		}
+		for _, e := range entries {
+			if incidents[e.title] || !e.affects(names, len(wanted) == 0) {
+				continue
+			}
+			r.State = worse(r.State, stateDegraded)
+			msgs = append(msgs, fmt.Sprintf("incident: %s (%s)", e.title, e.status))
+		}
+	}
+	r.Message = strings.Join(msgs, "; ")
+}
+
+// feedIncident is an unresolved incident from a status page's Atom
+// feed.
+type feedIncident struct {
+	title string
+	// status is that of the incident's latest update, such as
+	// "investigating" or "monitoring".
+	status string
+	// affected lists the components affected, if known.
+	affected string
+}
+
+// affects reports whether the incident affects any of the named
+// components. Incidents not listing components affect all of them.
+func (f *feedIncident) affects(names map[string]bool, all bool) bool {
+	if all || f.affected == "" {
+		return true
+	}
+	affected := strings.ToLower(f.affected)
+	for name := range names {
+		if strings.Contains(affected, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// feedIncidents returns the unresolved incidents in the Atom feed at
+// u. Statuspage describes each incident's updates in its entry's
+// content, latest first, each starting with its status in bold,
+// followed by a line listing the components affected.
+func feedIncidents(s *service, u string) ([]*feedIncident, error) {
+	resp, err := fetch(s, u)
+	if err != nil {
+		return nil, err
+	}
+	if resp.status != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d %s", resp.status, http.StatusText(resp.status))
+	}
+	var feed struct {
+		Entries []struct {
+			Title   string `xml:"title"`
+			Content string `xml:"content"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal([]byte(resp.body), &feed); err != nil {
+		return nil, fmt.Errorf("invalid feed: %v", err)
+	}
+	var incidents []*feedIncident
+	for _, e := range feed.Entries {
+		_, status, _ := strings.Cut(e.Content, "<strong>")
+		status, _, _ = strings.Cut(status, "</strong>")
+		status = strings.ToLower(strings.TrimSpace(status))
+		switch status {
+		case "", "resolved", "completed", "postmortem":
+			continue
+		}
+		_, affected, _ := strings.Cut(e.Content, "This incident affected: ")
+		affected, _, _ = strings.Cut(affected, "<")
+		incidents = append(incidents, &feedIncident{
+			title:    strings.TrimSpace(e.Title),
+			status:   status,
+			affected: strings.TrimSpace(affected),
+		})
+	}
+	return incidents, nil
+}
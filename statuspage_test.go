@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// statuspageSummaryFixture is a status page's summary, with a group of
+// components and incidents of each impact.
+const statuspageSummaryFixture = `{
+	"page": {"name": "Vendor"},
+	"components": [
+		{"id": "c1", "name": "API", "status": "operational"},
+		{"id": "g1", "name": "Actions", "status": "partial_outage", "group": true},
+		{"id": "c2", "name": "Actions Runners", "status": "major_outage", "group_id": "g1"},
+		{"id": "c3", "name": "Actions Cache", "status": "operational", "group_id": "g1"},
+		{"id": "c4", "name": "Pages", "status": "degraded_performance"},
+		{"id": "c5", "name": "Webhooks", "status": "under_maintenance"}
+	],
+	"incidents": [
+		{"name": "Slow pages", "impact": "minor", "components": [{"id": "c4"}]},
+		{"name": "Runner failures", "impact": "critical", "components": [{"id": "c2"}]},
+		{"name": "Elevated errors", "impact": "none", "components": []}
+	]
+}`
+
+// statuspageFeedFixture is a status page's Atom feed of incidents.
+const statuspageFeedFixture = `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Vendor Status - Incident History</title>
+  <entry>
+    <title>Runner failures</title>
+    <content type="html">&lt;p&gt;&lt;strong&gt;Investigating&lt;/strong&gt; - Jobs are failing.&lt;/p&gt;&lt;p&gt;This incident affected: Actions Runners.&lt;/p&gt;</content>
+  </entry>
+  <entry>
+    <title>Webhook delays</title>
+    <content type="html">&lt;p&gt;&lt;small&gt;Oct &lt;var data-var='date'&gt;16&lt;/var&gt;&lt;/small&gt;&lt;br&gt;&lt;strong&gt;Monitoring&lt;/strong&gt; - A fix has been deployed.&lt;/p&gt;&lt;p&gt;&lt;strong&gt;Investigating&lt;/strong&gt; - Deliveries are delayed.&lt;/p&gt;&lt;p&gt;This incident affected: Webhooks.&lt;/p&gt;</content>
+  </entry>
+  <entry>
+    <title>API outage</title>
+    <content type="html">&lt;p&gt;&lt;strong&gt;Resolved&lt;/strong&gt; - This incident has been resolved.&lt;/p&gt;&lt;p&gt;This incident affected: API.&lt;/p&gt;</content>
+  </entry>
+  <entry>
+    <title>Git errors</title>
+    <content type="html">&lt;p&gt;&lt;strong&gt;Identified&lt;/strong&gt; - Pushes are failing.&lt;/p&gt;&lt;p&gt;This incident affected: Git Operations.&lt;/p&gt;</content>
+  </entry>
+  <entry>
+    <title>Login problems</title>
+    <content type="html">&lt;p&gt;&lt;strong&gt;Investigating&lt;/strong&gt; - We are investigating.&lt;/p&gt;</content>
+  </entry>
+</feed>`
+
+// fakeStatuspage serves the summary and feed fixtures, along with an
+// invalid summary at /invalid.json and a failing one at /failed.json.
+func fakeStatuspage(t *testing.T) string {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v2/summary.json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, statuspageSummaryFixture)
+	})
+	mux.HandleFunc("/history.atom", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, statuspageFeedFixture)
+	})
+	mux.HandleFunc("/invalid.json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "{")
+	})
+	mux.HandleFunc("/failed.json", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+func TestCheckStatuspage(t *testing.T) {
+	page := fakeStatuspage(t)
+	const (
+		elevated = "incident: Elevated errors (none impact)"
+		login    = "incident: Login problems (investigating)"
+	)
+	tests := []struct {
+		name   string
+		path   string
+		check  string
+		state  string
+		status int
+		msg    string
+	}{
+		// Groups aren't judged themselves, only their members.
+		{"all", "/", `{}`, stateDown, 200,
+			"Actions Runners: major outage; Pages: degraded performance; Webhooks: under maintenance; " +
+				"incident: Slow pages (minor impact); incident: Runner failures (critical impact); " + elevated},
+		// Incidents without components affect every component.
+		{"component", "", `{"components": ["api"]}`, stateUp, 200, elevated},
+		{"group", "", `{"components": ["Actions"]}`, stateDown, 200,
+			"Actions Runners: major outage; incident: Runner failures (critical impact); " + elevated},
+		{"member", "", `{"components": ["actions cache"]}`, stateUp, 200, elevated},
+		{"minor incident", "", `{"components": ["Pages"]}`, stateDegraded, 200,
+			"Pages: degraded performance; incident: Slow pages (minor impact); " + elevated},
+		{"missing component", "", `{"components": ["API", "Packages"]}`, stateDown, 200,
+			`no component "Packages"; ` + elevated},
+		// Feed incidents already in the summary, resolved or
+		// affecting other components are ignored.
+		{"feed", "", fmt.Sprintf(`{"components": ["Webhooks"], "feed": %q}`, page+"/history.atom"), stateDegraded, 200,
+			"Webhooks: under maintenance; " + elevated + "; incident: Webhook delays (monitoring); " + login},
+		{"feed all", "", fmt.Sprintf(`{"feed": %q}`, page+"/history.atom"), stateDown, 200,
+			"Actions Runners: major outage; Pages: degraded performance; Webhooks: under maintenance; " +
+				"incident: Slow pages (minor impact); incident: Runner failures (critical impact); " + elevated + "; " +
+				"incident: Webhook delays (monitoring); incident: Git errors (identified); " + login},
+		{"feed missing", "", fmt.Sprintf(`{"components": ["API"], "feed": %q}`, page+"/feed.atom"), stateDegraded, 200,
+			elevated + "; feed: unexpected status 404 Not Found"},
+		{"invalid summary", "/invalid.json", `{}`, stateDown, 200,
+			"invalid summary: unexpected end of JSON input"},
+		{"failed summary", "/failed.json", `{}`, stateDown, 503, "unexpected status 503 Service Unavailable"},
+	}
+	for _, tt := range tests {
+		cfg := newFakeNotifiers(t).config(t, `{}`, fmt.Sprintf(
+			`{"name": "vendor", "type": "statuspage", "url": %q, "statuspage": %s}`, page+tt.path, tt.check))
+		r := &result{}
+		checkStatuspage(cfg.Services[0], &serviceState{}, r)
+		if r.State != tt.state || r.Status != tt.status || r.Message != tt.msg {
+			t.Errorf("%s: %s %d %q, want %s %d %q", tt.name, r.State, r.Status, r.Message, tt.state, tt.status, tt.msg)
+		}
+	}
+}
+
+func TestSummaryURL(t *testing.T) {
+	for u, want := range map[string]string{
+		"https://www.githubstatus.com":                        "https://www.githubstatus.com/api/v2/summary.json",
+		"https://www.githubstatus.com/":                       "https://www.githubstatus.com/api/v2/summary.json",
+		"https://status.example.com/vendor":                   "https://status.example.com/vendor/api/v2/summary.json",
+		"https://www.githubstatus.com/api/v2/summary.json":    "https://www.githubstatus.com/api/v2/summary.json",
+		"https://www.githubstatus.com/api/v2/components.json": "https://www.githubstatus.com/api/v2/components.json",
+	} {
+		if got, err := summaryURL(u); err != nil || got != want {
+			t.Errorf("summaryURL(%s) = %s, %v, want %s", u, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// systemdCheck configures a check of a systemd unit.
+type systemdCheck struct {
+	Unit string `json:"unit"`
+	// User checks a unit of the user's service manager.
+	User bool `json:"user,omitempty"`
+	// MaxRestarts is the number of times the unit may have been
+	// restarted since the previous check before it is degraded.
+	MaxRestarts int `json:"max_restarts,omitempty"`
+}
+
+// unitStatus describes the status of a systemd unit. It is used for
+// output.
+type unitStatus struct {
+	LoadState   string `json:"load_state"`
+	ActiveState string `json:"active_state"`
+	SubState    string `json:"sub_state"`
+	Result      string `json:"result,omitempty"`
+	Restarts    int    `json:"restarts"`
+	NewRestarts int    `json:"new_restarts"`
+}
+
+// checkSystemd reads the unit's status with systemctl show. Active
+// units are up, and inactive or failed units down. Units that are
+// starting, stopping, or have restarted too often since the previous
+// check, are degraded.
+func checkSystemd(s *service, ss *serviceState, r *result) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	args := []string{"show", s.Systemd.Unit,
+		"--property=LoadState,ActiveState,SubState,Result,NRestarts"}
+	if s.Systemd.User {
+		args = append([]string{"--user"}, args...)
+	}
+	out, err := exec.CommandContext(ctx, "systemctl", args...).Output()
+	if err != nil {
+		r.State = stateDown
+		r.Message = fmt.Sprintf("systemctl: %v", err)
+		if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+			r.Message = fmt.Sprintf("systemctl: %s", bytes.TrimSpace(ee.Stderr))
+		}
+		return
+	}
+	u := &unitStatus{}
+	for _, line := range strings.Split(string(out), "\n") {
+		k, v, _ := strings.Cut(line, "=")
+		switch k {
+		case "LoadState":
+			u.LoadState = v
+		case "ActiveState":
+			u.ActiveState = v
+		case "SubState":
+			u.SubState = v
+		case "Result":
+			u.Result = v
+		case "NRestarts":
+			u.Restarts, _ = strconv.Atoi(v)
+		}
+	}
+	// The restart count is reset when a unit is stopped.
+	if ss.UnitRestarts != nil && u.Restarts >= *ss.UnitRestarts {
+		u.NewRestarts = u.Restarts - *ss.UnitRestarts
+	} else if ss.UnitRestarts != nil {
+		u.NewRestarts = u.Restarts
+	}
+	ss.UnitRestarts = &u.Restarts
+	r.Unit = u
+
+	desc := fmt.Sprintf("%s (%s)", u.ActiveState, u.SubState)
+	switch {
+	case u.LoadState != "loaded":
+		r.State = stateDown
+		r.Message = fmt.Sprintf("unit %s", u.LoadState)
+	case u.ActiveState == "inactive" || u.ActiveState == "failed":
+		r.State = stateDown
+		r.Message = desc
+		if u.Result != "" && u.Result != "success" {
+			r.Message += ": " + u.Result
+		}
+	case u.NewRestarts > s.Systemd.MaxRestarts:
+		r.State = stateDegraded
+		r.Message = fmt.Sprintf("%s, restarted %d times since last check", desc, u.NewRestarts)
+	case u.ActiveState == "active" || u.ActiveState == "reloading":
+		r.State = stateUp
+	default:
+		r.State = stateDegraded
+		r.Message = desc
+	}
+}